@@ -0,0 +1,107 @@
+package cmd
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "os"
+  "fmt"
+
+  "gopkg.in/yaml.v2"
+  "github.com/spf13/cobra"
+
+  "github.com/lancs-net/wayfinder/log"
+  "github.com/lancs-net/wayfinder/job"
+)
+
+var (
+  paramsCmd = &cobra.Command{
+    Use: "params",
+    Short: `Manage the parameters of a job`,
+    DisableFlagsInUseLine: true,
+  }
+  paramsImportCmd = &cobra.Command{
+    Use: "import [OPTIONS...] [FILE]",
+    Short: `Generate parameters from a Kconfig tree or .config`,
+    Run: doParamsImportCmd,
+    Args: cobra.ExactArgs(1),
+    DisableFlagsInUseLine: true,
+  }
+  paramsFrom = &job.JobParamsFrom{
+    Type: "kconfig",
+  }
+)
+
+func init() {
+  paramsImportCmd.PersistentFlags().StringVarP(
+    &paramsFrom.Srctree,
+    "srctree",
+    "s",
+    "",
+    "Directory which sourced Kconfig files are relative to (default is the directory of FILE).",
+  )
+  paramsImportCmd.PersistentFlags().StringSliceVarP(
+    &paramsFrom.Include,
+    "include",
+    "i",
+    nil,
+    "Only import options matching these patterns, e.g. LWIP_*.",
+  )
+  paramsImportCmd.PersistentFlags().StringSliceVarP(
+    &paramsFrom.Exclude,
+    "exclude",
+    "x",
+    nil,
+    "Do not import options matching these patterns.",
+  )
+
+  paramsCmd.AddCommand(paramsImportCmd)
+}
+
+// doParamsImportCmd prints the parameters generated from the file as YAML
+func doParamsImportCmd(cmd *cobra.Command, args []string) {
+  paramsFrom.Path = args[0]
+
+  params, err := job.ImportParams(paramsFrom)
+  if err != nil {
+    log.Errorf("Could not import parameters: %s", err)
+    os.Exit(1)
+  }
+
+  b, err := yaml.Marshal(struct {
+    Params []job.JobParam `yaml:"params"`
+  }{params})
+  if err != nil {
+    log.Errorf("Could not marshal parameters: %s", err)
+    os.Exit(1)
+  }
+
+  fmt.Print(string(b))
+}
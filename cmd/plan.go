@@ -0,0 +1,202 @@
+package cmd
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "os"
+  "fmt"
+  "time"
+  "runtime"
+  "strings"
+  "text/tabwriter"
+
+  "github.com/spf13/cobra"
+
+  "github.com/lancs-net/wayfinder/log"
+  "github.com/lancs-net/wayfinder/job"
+)
+
+var (
+  planCmd = &cobra.Command{
+    Use: "plan [OPTIONS...] [FILE]",
+    Short: `Show the tasks of a job and estimate how long it would take`,
+    Run: doPlanCmd,
+    Args: cobra.ExactArgs(1),
+    DisableFlagsInUseLine: true,
+  }
+  planCpuSets         string
+  planWorkDir         string
+  planRepeat          int
+  planSysfsRoot       string
+  planReserveSiblings bool
+)
+
+// planBlockSize is the number of rows of the matrix which are aligned together
+const planBlockSize = 1000
+
+func init() {
+  planCmd.PersistentFlags().StringVar(
+    &planCpuSets,
+    "cpu-sets",
+    fmt.Sprintf("2-%d", runtime.NumCPU()),
+    "Specify which CPUs the experiments would run on.",
+  )
+  planCmd.PersistentFlags().StringVarP(
+    &planWorkDir,
+    "workdir",
+    "w",
+    "",
+    "Specify working directory with the results of previous jobs to estimate durations from.",
+  )
+  planCmd.PersistentFlags().IntVar(
+    &planRepeat,
+    "repeat",
+    0,
+    "Number of repetitions of the repeated runs, or of the last runs if none are.",
+  )
+  planCmd.PersistentFlags().StringVar(
+    &planSysfsRoot,
+    "sysfs",
+    "/sys",
+    "Specify where sysfs is mounted to read the CPU topology from.",
+  )
+  planCmd.PersistentFlags().BoolVar(
+    &planReserveSiblings,
+    "reserve-siblings",
+    false,
+    "Keep the SMT siblings of the cores of a run idle.",
+  )
+}
+
+// doPlanCmd prints the matrix of tasks, the runs of each task and the
+// estimated makespan of the job
+func doPlanCmd(cmd *cobra.Command, args []string) {
+  cpus, err := parseCpuSets(planCpuSets)
+  if err != nil {
+    log.Errorf("Could not parse CPU sets: %s", err)
+    os.Exit(1)
+  }
+
+  plan, errs := job.NewPlan(args[0], &job.RuntimeConfig{
+    Cpus:            cpus,
+    WorkDir:         planWorkDir,
+    Repeat:          planRepeat,
+    SysfsRoot:       planSysfsRoot,
+    ReserveSiblings: planReserveSiblings,
+  })
+  if len(errs) > 0 {
+    for _, err := range errs {
+      log.Errorf("%s", err)
+    }
+    os.Exit(1)
+  }
+
+  w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+  // Print the parameter matrix, where inactive parameters are shown in
+  // brackets.  Rows are aligned in blocks so that they are not all buffered.
+  fmt.Fprintf(w, "#\t%s\n", strings.Join(plan.Params, "\t"))
+  err = plan.EachTask(func(i int, params []job.TaskParam) {
+    values := make(map[string]string)
+    for _, param := range params {
+      if param.Inactive {
+        values[param.Name] = fmt.Sprintf("(%s)", param.Value)
+      } else {
+        values[param.Name] = param.Value
+      }
+    }
+
+    row := []string{fmt.Sprintf("%d", i + 1)}
+    for _, name := range plan.Params {
+      if val, ok := values[name]; ok {
+        row = append(row, val)
+      } else {
+        row = append(row, "-")
+      }
+    }
+    fmt.Fprintf(w, "%s\n", strings.Join(row, "\t"))
+
+    if (i + 1) % planBlockSize == 0 {
+      w.Flush()
+    }
+  })
+  if err != nil {
+    log.Errorf("Could not list tasks: %s", err)
+    os.Exit(1)
+  }
+  fmt.Fprintf(w, "\n")
+
+  // Print the runs of each task
+  var unknown []string
+  fmt.Fprintf(w, "RUN\tCORES\tREPEAT\tAT ONCE\tDURATION\n")
+  for _, r := range plan.Runs {
+    duration := "unknown"
+    if r.Duration > 0 {
+      duration = fmt.Sprintf("%s (%s)", r.Duration.Round(time.Second), r.Source)
+    } else {
+      unknown = append(unknown, r.Name)
+    }
+    fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\n", r.Name, r.Cores, r.Repeat, r.Concurrency, duration)
+  }
+  fmt.Fprintf(w, "\n")
+
+  fmt.Fprintf(w, "Explorer:\t%s\n", plan.Explorer)
+  if plan.Budget < plan.Count {
+    fmt.Fprintf(w, "Tasks:\t%d of %d (%d pruned by constraints, %d permutations)\n",
+      plan.Budget,
+      plan.Count,
+      plan.Pruned,
+      plan.Size,
+    )
+  } else {
+    fmt.Fprintf(w, "Tasks:\t%d (%d pruned by constraints, %d permutations)\n",
+      plan.Count,
+      plan.Pruned,
+      plan.Size,
+    )
+  }
+  runs := 0
+  for _, r := range plan.Runs {
+    runs += r.Repeat
+  }
+  fmt.Fprintf(w, "Runs:\t%d\n", plan.Budget * runs)
+  fmt.Fprintf(w, "CPUs:\t%d\n", plan.Cpus)
+  fmt.Fprintf(w, "Concurrency:\t%d runs at once\n", plan.Concurrency)
+  if plan.Makespan > 0 {
+    fmt.Fprintf(w, "Makespan:\t%s\n", plan.Makespan.Round(time.Second))
+  } else if len(unknown) > 0 {
+    fmt.Fprintf(w, "Makespan:\tunknown, runs have no duration: %s\n", strings.Join(unknown, ", "))
+  } else {
+    fmt.Fprintf(w, "Makespan:\tunknown\n")
+  }
+
+  w.Flush()
+}
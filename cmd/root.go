@@ -75,6 +75,9 @@ func init() {
 	rootCmd.AddCommand(runCmd)
 	rootCmd.AddCommand(versionCmd)
   rootCmd.AddCommand(runcInitCmd)
+  rootCmd.AddCommand(paramsCmd)
+  rootCmd.AddCommand(validateCmd)
+  rootCmd.AddCommand(planCmd)
 }
 
 // initLogging prepares logrus with sensible defaults
@@ -37,24 +37,33 @@ import (
   "strings"
   "strconv"
   "runtime"
+  "context"
+  "time"
   "os/signal"
 
 	"github.com/spf13/cobra"
 
 	"github.com/lancs-net/wayfinder/log"
 	"github.com/lancs-net/wayfinder/job"
+	"github.com/lancs-net/wayfinder/run"
 )
 
 type RunConfig struct {
-  CpuSets       string
-  DryRun        bool
-  ScheduleGrace int
-  WorkDir       string
-  AllowOverride bool
-  HostNetwork   string
-  BridgeName    string
-  BridgeSubnet  string
-  MaxRetries    int
+  CpuSets         string
+  DryRun          bool
+  ScheduleGrace   int
+  WorkDir         string
+  AllowOverride   bool
+  HostNetwork     string
+  BridgeName      string
+  BridgeSubnet    string
+  MaxRetries      int
+  SysfsRoot       string
+  ReserveSiblings bool
+  Memory          string
+  Repeat          int
+  Timeout         time.Duration
+  KillGrace       time.Duration
 }
 
 var (
@@ -87,8 +96,8 @@ func init() {
     &runConfig.ScheduleGrace,
     "schedule-grace-time",
     "g",
-    1,
-    "Number of seconds to gracefully wait in the scheduler.",
+    0,
+    "Number of seconds to wait between consecutive run launches.",
   )
   runCmd.PersistentFlags().StringVarP(
     &runConfig.WorkDir,
@@ -132,6 +141,43 @@ func init() {
     0,
     "Maximum number of retries for a run.",
   )
+  runCmd.PersistentFlags().StringVar(
+    &runConfig.SysfsRoot,
+    "sysfs",
+    "/sys",
+    "Specify where sysfs is mounted to read the CPU topology from.",
+  )
+  runCmd.PersistentFlags().BoolVar(
+    &runConfig.ReserveSiblings,
+    "reserve-siblings",
+    false,
+    "Keep the SMT siblings of the cores of a run idle.",
+  )
+  runCmd.PersistentFlags().StringVarP(
+    &runConfig.Memory,
+    "memory",
+    "m",
+    "",
+    "Memory available to runs with a memory limit, e.g. 64G (default is the total memory of the host).",
+  )
+  runCmd.PersistentFlags().IntVar(
+    &runConfig.Repeat,
+    "repeat",
+    0,
+    "Number of repetitions of the repeated runs, or of the last runs if none are.",
+  )
+  runCmd.PersistentFlags().DurationVar(
+    &runConfig.Timeout,
+    "timeout",
+    0,
+    "Time after which runs without a timeout are stopped, e.g. 30m (default is no timeout).",
+  )
+  runCmd.PersistentFlags().DurationVar(
+    &runConfig.KillGrace,
+    "kill-grace",
+    run.DefaultKillGrace,
+    "Time a run which timed out is given to stop before it is killed.",
+  )
 }
 
 // doRunCmd 
@@ -143,6 +189,15 @@ func doRunCmd(cmd *cobra.Command, args []string) {
     os.Exit(1)
   }
 
+  var memory int64
+  if runConfig.Memory != "" {
+    memory, err = run.ParseSize(runConfig.Memory)
+    if err != nil {
+      log.Errorf("Could not parse memory: %s", err)
+      os.Exit(1)
+    }
+  }
+
   // Set the working directory to the current directory if unset
   if runConfig.WorkDir == "" {
     runConfig.WorkDir, err = os.Getwd()
@@ -168,22 +223,29 @@ func doRunCmd(cmd *cobra.Command, args []string) {
     os.MkdirAll(rersultsDir, os.ModePerm)
   }
 
-  activeJob, err := job.NewJob(args[0], &job.RuntimeConfig{
-    Cpus:          cpus,
-    BridgeName:    runConfig.BridgeName,
-    BridgeIface:   runConfig.HostNetwork,
-    BridgeSubnet:  runConfig.BridgeSubnet,
-    ScheduleGrace: runConfig.ScheduleGrace,
-    AllowOverride: runConfig.AllowOverride,
-    WorkDir:       runConfig.WorkDir,
-    MaxRetries:    runConfig.MaxRetries,
+  activeJob, err = job.NewJob(args[0], &job.RuntimeConfig{
+    Cpus:            cpus,
+    BridgeName:      runConfig.BridgeName,
+    BridgeIface:     runConfig.HostNetwork,
+    BridgeSubnet:    runConfig.BridgeSubnet,
+    ScheduleGrace:   runConfig.ScheduleGrace,
+    AllowOverride:   runConfig.AllowOverride,
+    WorkDir:         runConfig.WorkDir,
+    MaxRetries:      runConfig.MaxRetries,
+    SysfsRoot:       runConfig.SysfsRoot,
+    ReserveSiblings: runConfig.ReserveSiblings,
+    Memory:          memory,
+    Repeat:          runConfig.Repeat,
+    Timeout:         runConfig.Timeout,
+    KillGrace:       runConfig.KillGrace,
   }, runConfig.DryRun)
 	if err != nil {
 		log.Fatalf("Could not read configuration: %s", err)
 		os.Exit(1)
 	}
 
-  setupInterruptHandler()
+  ctx, cancel := context.WithCancel(context.Background())
+  setupInterruptHandler(cancel)
 
   // Prepare environment
   err = job.PrepareEnvironment(cpus, runConfig.DryRun)
@@ -194,10 +256,11 @@ func doRunCmd(cmd *cobra.Command, args []string) {
   }
 
   // Start the job with its various tasks
-  err = activeJob.Start()
+  err = activeJob.Start(ctx)
   if err != nil {
     log.Errorf("Could not start job: %s", err)
     cleanup()
+    os.Exit(1)
   }
 
   // We're all done now
@@ -244,11 +307,14 @@ func parseCpuSets(cpuSets string) ([]int, error) {
   return cpus, nil
 }
 
-// Create a Ctrl+C trap for reverting machine state
-func setupInterruptHandler() {
+// Create a Ctrl+C trap for reverting machine state.  The first interrupt stops
+// the job, a second one cleans up immediately if the job does not stop in time.
+func setupInterruptHandler(cancel context.CancelFunc) {
   c := make(chan os.Signal, 1)
   signal.Notify(c, os.Interrupt)
   go func(){
+    <-c
+    cancel()
     <-c
     cleanup()
     os.Exit(1)
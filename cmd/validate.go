@@ -0,0 +1,86 @@
+package cmd
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "os"
+  "fmt"
+  "runtime"
+
+  "github.com/spf13/cobra"
+
+  "github.com/lancs-net/wayfinder/log"
+  "github.com/lancs-net/wayfinder/job"
+)
+
+var (
+  validateCmd = &cobra.Command{
+    Use: "validate [OPTIONS...] [FILE]",
+    Short: `Check a job for problems without running it`,
+    Run: doValidateCmd,
+    Args: cobra.ExactArgs(1),
+    DisableFlagsInUseLine: true,
+  }
+  validateCpuSets string
+)
+
+func init() {
+  validateCmd.PersistentFlags().StringVar(
+    &validateCpuSets,
+    "cpu-sets",
+    fmt.Sprintf("2-%d", runtime.NumCPU()),
+    "Specify which CPUs the experiments would run on.",
+  )
+}
+
+// doValidateCmd reports all the problems with the job and exits with a non-zero
+// code when there are any
+func doValidateCmd(cmd *cobra.Command, args []string) {
+  cpus, err := parseCpuSets(validateCpuSets)
+  if err != nil {
+    log.Errorf("Could not parse CPU sets: %s", err)
+    os.Exit(1)
+  }
+
+  errs := job.ValidateJob(args[0], &job.RuntimeConfig{
+    Cpus: cpus,
+  })
+  for _, err := range errs {
+    log.Errorf("%s", err)
+  }
+
+  if len(errs) > 0 {
+    log.Errorf("Found %d problems in %s", len(errs), args[0])
+    os.Exit(1)
+  }
+
+  log.Successf("%s is valid", args[0])
+}
@@ -5,10 +5,10 @@ if [[ -z $RESULTSDIR ]]; then
   RESULTSDIR=$(pwd)/results
 fi
 
-TASKSFILE=${TASKS:-$RESULTSDIR/tasks.json}
+TASKSFILE=${TASKS:-$RESULTSDIR/tasks.jsonl}
 
 if [[ ! -f $TASKSFILE ]]; then
-  echo "Missing tasks.json file!"
+  echo "Missing tasks.jsonl file!"
   exit 1
 fi
 
@@ -19,22 +19,22 @@ fi
 
 echo -n "TASKID,"
 FIRST=y
-for TASKID in $(cat $TASKSFILE | jq -r "keys[]"); do
+for TASKID in $(cat $TASKSFILE | jq -r ".uuid" | sort -u); do
   if [[ -f $RESULTSDIR/$TASKID/results.txt ]]; then
-    cat $TASKSFILE | jq -r ".\"$TASKID\"|keys" | sed -e '1d' -e '$ d' | tr '\r\n' ' ' | sed 's/",   "/,/g' | sed 's/  "//g' | sed 's/" /,/g'
+    cat $TASKSFILE | jq -rs "map(select(.uuid == \"$TASKID\"))[0].params | keys" | sed -e '1d' -e '$ d' | tr '\r\n' ' ' | sed 's/",   "/,/g' | sed 's/  "//g' | sed 's/" /,/g'
     cat $RESULTSDIR/$TASKID/results.txt | sed -e '6,10d' | wrkp | head -1
     break
   fi
 done
 
-for TASKID in $(cat $TASKSFILE | jq -r "keys[]"); do
+for TASKID in $(cat $TASKSFILE | jq -r ".uuid" | sort -u); do
   _jq() {
-    cat $TASKSFILE | jq -r ".\"$TASKID\".\"${1}\""
+    cat $TASKSFILE | jq -rs "map(select(.uuid == \"$TASKID\"))[0].params.\"${1}\""
   }
 
   echo -n "${TASKID},"
 
-  cat $TASKSFILE | jq -r ".\"$TASKID\"" | gron | sed -e '1d' | awk -F'=' '{print $2}' | sed 's/["\ ;]//g' | tr ';\r\n' ','
+  cat $TASKSFILE | jq -rs "map(select(.uuid == \"$TASKID\"))[0].params" | gron | sed -e '1d' | awk -F'=' '{print $2}' | sed 's/["\ ;]//g' | tr ';\r\n' ','
 
   DAT=""
   if [[ -f $RESULTSDIR/$TASKID/results.txt ]]; then
@@ -0,0 +1,629 @@
+package job
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "fmt"
+  "math"
+  "sort"
+  "time"
+  "strconv"
+  "math/rand"
+
+  "github.com/lancs-net/wayfinder/log"
+)
+
+const (
+  // bayesianCandidates is the number of random permutations on which the
+  // acquisition function is evaluated when proposing a new task.
+  bayesianCandidates = 512
+  // bayesianNeighbours is the number of permutations neighbouring the best
+  // known task on which the acquisition function is also evaluated.
+  bayesianNeighbours = 128
+)
+
+// BayesianExplorer proposes tasks which maximise the expected improvement of
+// the job's objective, as predicted by a Gaussian process fitted to the
+// results of completed tasks.
+type BayesianExplorer struct {
+  job      *Job
+  dims   []dimension
+  encoding [][][]float64
+  rand     *rand.Rand
+  seed      int64
+  budget    int
+  initial   int
+  proposed  int
+  size      int64
+  metric    string
+  maximize  bool
+  seen      map[string]bool
+  pending   map[string][]int
+  points  [][]int
+  values  []float64
+}
+
+// NewBayesianExplorer creates a Bayesian optimisation explorer with the seed,
+// budget and number of initial random tasks set in the explorer configuration.
+func NewBayesianExplorer(cfg *JobExplorer) (*BayesianExplorer, error) {
+  if cfg.Budget <= 0 {
+    return nil, fmt.Errorf("Bayesian explorer requires a budget greater than 0")
+  }
+
+  seed := cfg.Seed
+  if seed == 0 {
+    seed = time.Now().UnixNano()
+  }
+
+  return &BayesianExplorer{
+    seed:    seed,
+    budget:  cfg.Budget,
+    initial: cfg.Initial,
+  }, nil
+}
+
+// Init determines the parameter space and how each of its values is encoded
+// for the surrogate model.
+func (e *BayesianExplorer) Init(job *Job) error {
+  objective := job.explorerObjective()
+  if objective == nil {
+    return fmt.Errorf("Bayesian explorer requires an objective")
+  }
+
+  maximize, err := objective.Maximize()
+  if err != nil {
+    return err
+  }
+
+  dims, err := job.dimensions()
+  if err != nil {
+    return err
+  }
+
+  e.job = job
+  e.dims = dims
+  e.encoding = encodeDimensions(dims)
+  e.rand = rand.New(rand.NewSource(e.seed))
+  e.size = spaceSize(dims)
+  e.metric = objective.Metric
+  e.maximize = maximize
+  e.seen = make(map[string]bool)
+  e.pending = make(map[string][]int)
+  e.proposed = 0
+
+  // Use a small random design to seed the model when unset
+  if e.initial <= 0 {
+    e.initial = 2 * len(dims)
+    if e.initial < 4 {
+      e.initial = 4
+    }
+  }
+
+  if int64(e.budget) > e.size {
+    log.Warnf("Budget exceeds the size of the parameter space: %d > %d", e.budget, e.size)
+    e.budget = int(e.size)
+  }
+
+  log.Infof("Using bayesian explorer with seed %d", e.seed)
+
+  return nil
+}
+
+// encodeDimensions maps every value of each dimension to a vector of reals.
+// Numeric dimensions are encoded by the rank of the value within [0, 1] and
+// all other dimensions are one-hot encoded.
+func encodeDimensions(dims []dimension) [][][]float64 {
+  encoding := make([][][]float64, len(dims))
+
+  for d, dim := range dims {
+    encoding[d] = make([][]float64, len(dim))
+
+    // A dimension with a single value carries no information
+    if len(dim) == 1 {
+      encoding[d][0] = []float64{}
+      continue
+    }
+
+    // Grouped parameters vary together so the first one orders the dimension
+    first := dim[0][0]
+    numeric := first.Type == "int" || first.Type == "integer" || first.Type == "float"
+    nums := make([]float64, len(dim))
+    for i, params := range dim {
+      num, err := strconv.ParseFloat(params[0].Value, 64)
+      if err != nil {
+        numeric = false
+        break
+      }
+      nums[i] = num
+    }
+
+    if numeric {
+      sorted := make([]float64, len(nums))
+      copy(sorted, nums)
+      sort.Float64s(sorted)
+
+      for i, num := range nums {
+        rank := sort.SearchFloat64s(sorted, num)
+        encoding[d][i] = []float64{float64(rank) / float64(len(dim) - 1)}
+      }
+    } else {
+      for i := range dim {
+        encoding[d][i] = make([]float64, len(dim))
+        encoding[d][i][i] = 1
+      }
+    }
+  }
+
+  return encoding
+}
+
+// encode returns the vector of reals representing the permutation
+func (e *BayesianExplorer) encode(point []int) []float64 {
+  var x []float64
+  for d, i := range point {
+    x = append(x, e.encoding[d][i]...)
+  }
+
+  return x
+}
+
+// task creates a task from the indices of each dimension's value
+func (e *BayesianExplorer) task(point []int) *Task {
+  return e.job.newTask(dimensionParams(e.dims, point))
+}
+
+// candidate returns whether the permutation has not yet been seen and
+// satisfies the job's constraints.
+func (e *BayesianExplorer) candidate(point []int) (bool, error) {
+  task := e.task(point)
+  if e.seen[task.UUID()] {
+    return false, nil
+  }
+
+  ok, err := e.job.feasible(task.Params)
+  if err != nil {
+    return false, err
+  }
+  if !ok {
+    e.seen[task.UUID()] = true
+  }
+
+  return ok, nil
+}
+
+// random returns a permutation which is a candidate, or nil when it could not
+// find one.
+func (e *BayesianExplorer) random() ([]int, error) {
+  for attempt := 0; attempt < 100; attempt++ {
+    point := make([]int, len(e.dims))
+    for d, dim := range e.dims {
+      point[d] = e.rand.Intn(len(dim))
+    }
+
+    ok, err := e.candidate(point)
+    if err != nil {
+      return nil, err
+    }
+    if ok {
+      return point, nil
+    }
+  }
+
+  return nil, nil
+}
+
+// neighbour returns a copy of the permutation with one dimension changed
+func (e *BayesianExplorer) neighbour(point []int) []int {
+  next := make([]int, len(point))
+  copy(next, point)
+
+  d := e.rand.Intn(len(e.dims))
+  if len(e.dims[d]) > 1 {
+    next[d] = (next[d] + 1 + e.rand.Intn(len(e.dims[d]) - 1)) % len(e.dims[d])
+  }
+
+  return next
+}
+
+// best returns the permutation with the best known objective value
+func (e *BayesianExplorer) best() []int {
+  var best []int
+  bestVal := math.Inf(1)
+  for i, val := range e.values {
+    if val < bestVal {
+      best = e.points[i]
+      bestVal = val
+    }
+  }
+
+  return best
+}
+
+// propose chooses the unseen permutation with the highest expected improvement
+// given the observed and pending tasks.
+func (e *BayesianExplorer) propose(xs [][]float64, ys []float64) ([]int, float64, error) {
+  gp, err := fitGaussianProcess(xs, ys)
+  if err != nil {
+    return nil, 0, err
+  }
+
+  var candidates [][]int
+  for i := 0; i < bayesianCandidates; i++ {
+    point, err := e.random()
+    if err != nil {
+      return nil, 0, err
+    }
+    if point != nil {
+      candidates = append(candidates, point)
+    }
+  }
+  if best := e.best(); best != nil {
+    for i := 0; i < bayesianNeighbours; i++ {
+      point := e.neighbour(best)
+      ok, err := e.candidate(point)
+      if err != nil {
+        return nil, 0, err
+      }
+      if ok {
+        candidates = append(candidates, point)
+      }
+    }
+  }
+
+  minVal := math.Inf(1)
+  for _, val := range e.values {
+    minVal = math.Min(minVal, val)
+  }
+
+  var next []int
+  var nextMean float64
+  nextEI := math.Inf(-1)
+  for _, point := range candidates {
+    if e.seen[e.task(point).UUID()] {
+      continue
+    }
+
+    mean, stddev := gp.predict(e.encode(point))
+    ei := expectedImprovement(mean, stddev, minVal, 0.01 * gp.scale)
+    if ei > nextEI {
+      next = point
+      nextMean = mean
+      nextEI = ei
+    }
+  }
+
+  return next, nextMean, nil
+}
+
+// Next proposes up to n new tasks.  Until the initial number of tasks has been
+// proposed, tasks are sampled at random.  Afterwards, each task in the batch
+// is chosen by the surrogate model, assuming previously chosen and pending
+// tasks result in the value predicted by the model.
+func (e *BayesianExplorer) Next(n int) ([]*Task, error) {
+  var tasks []*Task
+
+  if n <= 0 {
+    n = e.initial
+  }
+
+  var xs [][]float64
+  var ys []float64
+  for i, point := range e.points {
+    xs = append(xs, e.encode(point))
+    ys = append(ys, e.values[i])
+  }
+
+  for len(tasks) < n && !e.Done() {
+    var point []int
+
+    if e.proposed < e.initial || len(e.values) < 2 {
+      // Without enough results, there is nothing to model and we wait for the
+      // tasks in flight unless none remain.
+      if e.proposed >= e.initial && len(e.pending) > 0 {
+        break
+      }
+
+      var err error
+      point, err = e.random()
+      if err != nil {
+        return tasks, err
+      }
+
+    } else {
+      // Believe the model's prediction for tasks which are yet to finish
+      if len(xs) == len(e.points) {
+        gp, err := fitGaussianProcess(xs, ys)
+        if err != nil {
+          return tasks, err
+        }
+        var uuids []string
+        for uuid := range e.pending {
+          uuids = append(uuids, uuid)
+        }
+        sort.Strings(uuids)
+
+        for _, uuid := range uuids {
+          x := e.encode(e.pending[uuid])
+          mean, _ := gp.predict(x)
+          xs = append(xs, x)
+          ys = append(ys, mean)
+        }
+      }
+
+      var mean float64
+      var err error
+      point, mean, err = e.propose(xs, ys)
+      if err != nil {
+        return tasks, err
+      }
+
+      if point != nil {
+        xs = append(xs, e.encode(point))
+        ys = append(ys, mean)
+      }
+    }
+
+    if point == nil {
+      log.Warnf("Could not find a permutation which has not been proposed")
+      e.budget = e.proposed
+      break
+    }
+
+    task := e.task(point)
+    e.seen[task.UUID()] = true
+    e.pending[task.UUID()] = point
+    e.proposed++
+    tasks = append(tasks, task)
+  }
+
+  return tasks, nil
+}
+
+// Report adds the task's objective value to the observations of the model
+func (e *BayesianExplorer) Report(task *Task) {
+  point, ok := e.pending[task.UUID()]
+  if !ok {
+    return
+  }
+
+  delete(e.pending, task.UUID())
+
+  val, ok := task.Metrics[e.metric]
+  if !ok {
+    log.Warnf("Task %s has no value for %s", task.UUID(), e.metric)
+    return
+  }
+
+  // The model always minimizes
+  if e.maximize {
+    val = -val
+  }
+
+  e.points = append(e.points, point)
+  e.values = append(e.values, val)
+}
+
+// Done returns whether the budget of tasks has been proposed
+func (e *BayesianExplorer) Done() bool {
+  return e.proposed >= e.budget || int64(len(e.seen)) >= e.size
+}
+
+// gaussianProcess is a Gaussian process regression model with a squared
+// exponential kernel fitted to standardized observations.
+type gaussianProcess struct {
+  xs        [][]float64
+  chol      [][]float64
+  alpha     []float64
+  lengthscale float64
+  noise       float64
+  mean        float64
+  scale       float64
+}
+
+// fitGaussianProcess fits a Gaussian process to the observations, selecting
+// the lengthscale which maximises the marginal likelihood.
+func fitGaussianProcess(xs [][]float64, ys []float64) (*gaussianProcess, error) {
+  if len(xs) == 0 {
+    return nil, fmt.Errorf("Cannot fit model without observations")
+  }
+
+  mean := 0.0
+  for _, y := range ys {
+    mean += y
+  }
+  mean /= float64(len(ys))
+
+  scale := 0.0
+  for _, y := range ys {
+    scale += (y - mean) * (y - mean)
+  }
+  scale = math.Sqrt(scale / float64(len(ys)))
+  if scale == 0 {
+    scale = 1
+  }
+
+  norm := make([]float64, len(ys))
+  for i, y := range ys {
+    norm[i] = (y - mean) / scale
+  }
+
+  var best *gaussianProcess
+  bestLikelihood := math.Inf(-1)
+
+  for _, lengthscale := range []float64{0.1, 0.2, 0.5, 1, 2} {
+    gp := &gaussianProcess{
+      xs:          xs,
+      lengthscale: lengthscale,
+      noise:       1e-2,
+      mean:        mean,
+      scale:       scale,
+    }
+
+    k := make([][]float64, len(xs))
+    for i := range xs {
+      k[i] = make([]float64, len(xs))
+      for j := range xs {
+        k[i][j] = gp.kernel(xs[i], xs[j])
+      }
+      k[i][i] += gp.noise
+    }
+
+    chol, err := cholesky(k)
+    if err != nil {
+      continue
+    }
+
+    gp.chol = chol
+    gp.alpha = solveCholesky(chol, norm)
+
+    // log p(y|X) = -y'a/2 - sum(log(diag(L))) - n*log(2pi)/2
+    likelihood := 0.0
+    for i := range norm {
+      likelihood -= 0.5 * norm[i] * gp.alpha[i]
+      likelihood -= math.Log(chol[i][i])
+    }
+
+    if likelihood > bestLikelihood {
+      best = gp
+      bestLikelihood = likelihood
+    }
+  }
+
+  if best == nil {
+    return nil, fmt.Errorf("Could not fit model to observations")
+  }
+
+  return best, nil
+}
+
+// kernel returns the squared exponential covariance of two points
+func (gp *gaussianProcess) kernel(a, b []float64) float64 {
+  dist := 0.0
+  for i := range a {
+    dist += (a[i] - b[i]) * (a[i] - b[i])
+  }
+
+  return math.Exp(-0.5 * dist / (gp.lengthscale * gp.lengthscale))
+}
+
+// predict returns the predicted mean and standard deviation at the point
+func (gp *gaussianProcess) predict(x []float64) (float64, float64) {
+  k := make([]float64, len(gp.xs))
+  for i := range gp.xs {
+    k[i] = gp.kernel(x, gp.xs[i])
+  }
+
+  mean := 0.0
+  for i := range k {
+    mean += k[i] * gp.alpha[i]
+  }
+
+  v := solveLower(gp.chol, k)
+  variance := 1.0
+  for i := range v {
+    variance -= v[i] * v[i]
+  }
+  if variance < 1e-12 {
+    variance = 1e-12
+  }
+
+  return mean * gp.scale + gp.mean, math.Sqrt(variance) * gp.scale
+}
+
+// expectedImprovement returns the expected improvement below best of a point
+// with the predicted mean and standard deviation.  Without any uncertainty,
+// this is the improvement itself if there is one.
+func expectedImprovement(mean, stddev, best, xi float64) float64 {
+  improvement := best - mean - xi
+  if stddev <= 0 {
+    return math.Max(improvement, 0)
+  }
+
+  z := improvement / stddev
+  cdf := 0.5 * math.Erfc(-z / math.Sqrt2)
+  pdf := math.Exp(-0.5 * z * z) / math.Sqrt(2 * math.Pi)
+
+  return improvement * cdf + stddev * pdf
+}
+
+// cholesky returns the lower triangular matrix L such that a = LL'
+func cholesky(a [][]float64) ([][]float64, error) {
+  l := make([][]float64, len(a))
+  for i := range a {
+    l[i] = make([]float64, len(a))
+    for j := 0; j <= i; j++ {
+      sum := a[i][j]
+      for k := 0; k < j; k++ {
+        sum -= l[i][k] * l[j][k]
+      }
+
+      if i == j {
+        if sum <= 0 {
+          return nil, fmt.Errorf("Matrix is not positive definite")
+        }
+        l[i][i] = math.Sqrt(sum)
+      } else {
+        l[i][j] = sum / l[j][j]
+      }
+    }
+  }
+
+  return l, nil
+}
+
+// solveLower solves Lx = b for the lower triangular matrix L
+func solveLower(l [][]float64, b []float64) []float64 {
+  x := make([]float64, len(b))
+  for i := range b {
+    sum := b[i]
+    for k := 0; k < i; k++ {
+      sum -= l[i][k] * x[k]
+    }
+    x[i] = sum / l[i][i]
+  }
+
+  return x
+}
+
+// solveCholesky solves LL'x = b for the lower triangular matrix L
+func solveCholesky(l [][]float64, b []float64) []float64 {
+  y := solveLower(l, b)
+  x := make([]float64, len(y))
+  for i := len(y) - 1; i >= 0; i-- {
+    sum := y[i]
+    for k := i + 1; k < len(y); k++ {
+      sum -= l[k][i] * x[k]
+    }
+    x[i] = sum / l[i][i]
+  }
+
+  return x
+}
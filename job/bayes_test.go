@@ -0,0 +1,223 @@
+package job
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "os"
+  "fmt"
+  "math"
+  "path"
+  "strconv"
+  "testing"
+  "io/ioutil"
+)
+
+// explorerJob returns a job with a small parameter space and the objectives,
+// whose results are written to a temporary directory
+func explorerJob(t *testing.T, explorer JobExplorer, objectives ...JobObjective) *Job {
+  workDir, err := ioutil.TempDir("", "wayfinder-explorer")
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  err = os.MkdirAll(path.Join(workDir, "results"), os.ModePerm)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  return &Job{
+    Params: []JobParam{
+      {Name: "X", Type: "int", Only: []string{"0", "1", "2", "3", "4", "5", "6", "7"}},
+      {Name: "Y", Type: "int", Only: []string{"0", "1", "2", "3"}},
+    },
+    Explorer:   explorer,
+    Objectives: objectives,
+    workDir:    workDir,
+  }
+}
+
+// paramValue returns the numeric value of the task's parameter
+func paramValue(task *Task, name string) float64 {
+  for _, param := range task.Params {
+    if param.Name == name {
+      val, _ := strconv.ParseFloat(param.Value, 64)
+      return val
+    }
+  }
+
+  return 0
+}
+
+// explore runs the explorer on the job until it is done, reporting the
+// metrics of each task as given by measure, and returns the proposed tasks
+func explore(t *testing.T, j *Job, measure func(task *Task) map[string]float64) []string {
+  defer os.RemoveAll(j.workDir)
+
+  explorer, err := NewExplorer(&j.Explorer)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  err = explorer.Init(j)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  var proposed []string
+  for !explorer.Done() {
+    tasks, err := explorer.Next(3)
+    if err != nil {
+      t.Fatal(err)
+    }
+    if len(tasks) == 0 {
+      t.Fatalf("Explorer proposed no tasks before it was done")
+    }
+
+    for _, task := range tasks {
+      proposed = append(proposed, task.UUID())
+      task.Metrics = measure(task)
+      explorer.Report(task)
+    }
+  }
+
+  return proposed
+}
+
+// TestCholesky checks the decomposition of a symmetric positive definite
+// matrix and the solution of a system with it
+func TestCholesky(t *testing.T) {
+  a := [][]float64{
+    {4, 12, -16},
+    {12, 37, -43},
+    {-16, -43, 98},
+  }
+  expect := [][]float64{
+    {2, 0, 0},
+    {6, 1, 0},
+    {-8, 5, 3},
+  }
+
+  l, err := cholesky(a)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  for i := range expect {
+    for k := range expect[i] {
+      if math.Abs(l[i][k] - expect[i][k]) > 1e-12 {
+        t.Errorf("Got L[%d][%d]=%g, expected %g", i, k, l[i][k], expect[i][k])
+      }
+    }
+  }
+
+  // A = LL' with x = (1, 2, 3) gives b = Ax
+  b := []float64{-20, -43, 192}
+  x := solveCholesky(l, b)
+  for i, val := range []float64{1, 2, 3} {
+    if math.Abs(x[i] - val) > 1e-9 {
+      t.Errorf("Got x[%d]=%g, expected %g", i, x[i], val)
+    }
+  }
+
+  _, err = cholesky([][]float64{{1, 2}, {2, 1}})
+  if err == nil {
+    t.Errorf("Decomposed a matrix which is not positive definite")
+  }
+}
+
+// TestExpectedImprovement checks the expected improvement below the best value
+// including when the prediction is certain
+func TestExpectedImprovement(t *testing.T) {
+  tests := []struct {
+    mean   float64
+    stddev float64
+    best   float64
+    expect float64
+  }{
+    {0, 1, 0, 1 / math.Sqrt(2 * math.Pi)},
+    {-1, 0, 0, 1},
+    {1, 0, 0, 0},
+    {0, 0, 0, 0},
+  }
+
+  for _, test := range tests {
+    ei := expectedImprovement(test.mean, test.stddev, test.best, 0)
+    if math.Abs(ei - test.expect) > 1e-12 {
+      t.Errorf("EI of %g±%g below %g: got %g, expected %g",
+        test.mean, test.stddev, test.best, ei, test.expect,
+      )
+    }
+  }
+
+  // A better or less certain prediction is expected to improve more
+  worse := expectedImprovement(1, 1, 0, 0)
+  if better := expectedImprovement(-1, 1, 0, 0); better <= worse || worse <= 0 {
+    t.Errorf("Got EI %g for a better prediction and %g for a worse one", better, worse)
+  }
+  if uncertain := expectedImprovement(1, 2, 0, 0); uncertain <= worse {
+    t.Errorf("Got EI %g for an uncertain prediction and %g otherwise", uncertain, worse)
+  }
+}
+
+// TestBayesianExplorer checks that the same seed proposes the same tasks, as
+// many as the budget allows
+func TestBayesianExplorer(t *testing.T) {
+  cost := func(task *Task) map[string]float64 {
+    x, y := paramValue(task, "X"), paramValue(task, "Y")
+    return map[string]float64{"cost": (x - 5) * (x - 5) + (y - 1) * (y - 1)}
+  }
+
+  run := func() []string {
+    j := explorerJob(t,
+      JobExplorer{Type: "bayesian", Seed: 42, Budget: 16, Initial: 4},
+      JobObjective{Metric: "cost", Path: "cost.txt"},
+    )
+    return explore(t, j, cost)
+  }
+
+  first := run()
+  second := run()
+
+  if len(first) != 16 {
+    t.Errorf("Got %d tasks, expected the budget of 16", len(first))
+  }
+  if fmt.Sprint(first) != fmt.Sprint(second) {
+    t.Errorf("Got different tasks with the same seed:\n%v\n%v", first, second)
+  }
+
+  seen := make(map[string]bool)
+  for _, uuid := range first {
+    if seen[uuid] {
+      t.Errorf("Task %s was proposed twice", uuid)
+    }
+    seen[uuid] = true
+  }
+}
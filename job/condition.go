@@ -0,0 +1,154 @@
+package job
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "fmt"
+  "sort"
+  "strconv"
+
+  "github.com/lancs-net/wayfinder/log"
+)
+
+// compileConditions parses the condition of each conditional parameter and
+// checks that it only refers to parameters defined before it.
+func (j *Job) compileConditions() error {
+  names := make(map[string]bool)
+
+  j.conditions = make(map[string]*expression)
+  types := j.paramTypes()
+
+  for _, param := range j.Params {
+    if len(param.When) > 0 {
+      condition, err := compileExpression(param.When, types)
+      if err != nil {
+        return fmt.Errorf("Invalid condition for %s: %s", param.Name, err)
+      }
+
+      for ident := range condition.idents {
+        if !names[ident] {
+          return fmt.Errorf(
+            "Condition for %s must only refer to parameters defined before it: %s",
+            param.Name,
+            ident,
+          )
+        }
+      }
+
+      j.conditions[param.Name] = condition
+    }
+
+    names[param.Name] = true
+  }
+
+  return nil
+}
+
+// active returns whether the parameter's condition is met by the values of
+// the parameters preceding it.
+func (j *Job) active(param *JobParam, params []TaskParam) bool {
+  condition, ok := j.conditions[param.Name]
+  if !ok {
+    return true
+  }
+
+  ok, err := condition.eval(params)
+  if err != nil {
+    log.Warnf("Could not evaluate condition for %s: %s", param.Name, err)
+    return false
+  }
+
+  return ok
+}
+
+// inactiveParam returns the parameter fixed to its default value
+func (j *Job) inactiveParam(param *JobParam) TaskParam {
+  value := param.Default
+  if param.Type == "float" {
+    if num, err := strconv.ParseFloat(value, 64); err == nil {
+      value = formatFloat(num)
+    }
+  }
+
+  return TaskParam{
+    Name:     param.Name,
+    Type:     param.Type,
+    Value:    value,
+    Inactive: true,
+  }
+}
+
+// resolve returns a copy of the parameters in the order they are defined,
+// where those whose condition is not met are fixed to their default or left
+// out when they have none.
+func (j *Job) resolve(params []TaskParam) []TaskParam {
+  var resolved = make([]TaskParam, 0, len(params))
+
+  // Keep parameters in the order they are defined, regardless of grouping, so
+  // that conditions only see preceding parameters and the UUID is stable
+  order := make(map[string]int)
+  for i, param := range j.Params {
+    order[param.Name] = i
+  }
+
+  sorted := make([]TaskParam, len(params))
+  copy(sorted, params)
+  sort.SliceStable(sorted, func(a, b int) bool {
+    return order[sorted[a].Name] < order[sorted[b].Name]
+  })
+
+  for _, param := range sorted {
+    if _, ok := j.conditions[param.Name]; ok {
+      jobParam := j.param(param.Name)
+      if !j.active(jobParam, resolved) {
+        if len(jobParam.Default) > 0 {
+          resolved = append(resolved, j.inactiveParam(jobParam))
+        }
+        continue
+      }
+    }
+
+    resolved = append(resolved, param)
+  }
+
+  return resolved
+}
+
+// param returns the job's parameter with the given name
+func (j *Job) param(name string) *JobParam {
+  for i := range j.Params {
+    if j.Params[i].Name == name {
+      return &j.Params[i]
+    }
+  }
+
+  return nil
+}
@@ -0,0 +1,170 @@
+package job
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "fmt"
+  "path"
+  "encoding/json"
+
+  "github.com/lancs-net/wayfinder/log"
+)
+
+// prunedTask is the serialized form of a task which was pruned because it did
+// not satisfy one of the job's constraints.
+type prunedTask struct {
+  UUID       string            `json:"uuid"`
+  Params     map[string]string `json:"params"`
+  Constraint string            `json:"constraint"`
+}
+
+// paramTypes returns the type of each of the job's parameters in expressions.
+// String parameters are numbers when all of their values are.
+func (j *Job) paramTypes() map[string]exprType {
+  types := make(map[string]exprType, len(j.Params))
+  for i, param := range j.Params {
+    switch param.Type {
+    case "int", "integer", "hex", "float":
+      types[param.Name] = exprNumber
+    case "string":
+      types[param.Name] = stringParamType(&j.Params[i])
+    }
+  }
+
+  return types
+}
+
+// stringParamType returns whether the values of the string parameter are all
+// numbers, all other strings or a mix of both
+func stringParamType(param *JobParam) exprType {
+  // Invalid parameters are reported on their own
+  values, err := paramPermutations(param)
+  if err != nil || len(values) == 0 {
+    return exprAny
+  }
+
+  numbers := 0
+  for _, value := range values {
+    if _, ok := parseNumber(value.Value); ok {
+      numbers++
+    }
+  }
+
+  if numbers == len(values) {
+    return exprNumber
+  } else if numbers == 0 {
+    return exprString
+  }
+
+  return exprAny
+}
+
+// compileConstraints parses each of the job's constraints and checks that
+// they only refer to the job's parameters.
+func (j *Job) compileConstraints() error {
+  names := make(map[string]bool)
+  for _, param := range j.Params {
+    names[param.Name] = true
+  }
+
+  j.constraints = nil
+  j.pruned = 0
+  types := j.paramTypes()
+
+  for _, src := range j.Constraints {
+    constraint, err := compileExpression(src, types)
+    if err != nil {
+      return err
+    }
+
+    for ident := range constraint.idents {
+      if !names[ident] {
+        return fmt.Errorf("Unknown parameter in constraint \"%s\": %s", src, ident)
+      }
+    }
+
+    j.constraints = append(j.constraints, constraint)
+  }
+
+  return nil
+}
+
+// feasible returns whether the parameters satisfy all of the job's
+// constraints.  Parameters which do not are counted and queued to be recorded
+// as pruned.  Explorers only check each permutation once.
+func (j *Job) feasible(params []TaskParam) (bool, error) {
+  params = j.resolve(params)
+
+  for _, constraint := range j.constraints {
+    ok, err := constraint.eval(params)
+    if err != nil {
+      return false, err
+    }
+
+    if !ok {
+      // Queue the pruned task to be recorded when exploring
+      task := j.newTask(params)
+      log.Debugf("Pruning task %s: %s", task.UUID(), constraint.src)
+      j.pruned++
+      j.prunedQueue = append(j.prunedQueue, prunedTask{
+        UUID:       task.UUID(),
+        Params:     task.paramsMap(),
+        Constraint: constraint.src,
+      })
+
+      return false, nil
+    }
+  }
+
+  return true, nil
+}
+
+// writePrunedFile appends the tasks which were pruned since it was last
+// written to the record of pruned tasks
+func (j *Job) writePrunedFile() error {
+  prunedFile := path.Join(j.workDir, "results", "pruned.jsonl")
+
+  for _, pruned := range j.prunedQueue {
+    b, err := json.Marshal(pruned)
+    if err != nil {
+      return fmt.Errorf("Could not marshal JSON of pruned task: %s", err)
+    }
+
+    err = appendLine(prunedFile, b)
+    if err != nil {
+      return err
+    }
+  }
+
+  j.prunedQueue = nil
+
+  return nil
+}
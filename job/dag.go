@@ -0,0 +1,404 @@
+package job
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "fmt"
+  "path"
+  "strings"
+
+  "github.com/lancs-net/wayfinder/log"
+  "github.com/lancs-net/wayfinder/run"
+)
+
+const (
+  runPending   = iota
+  runRunning
+  runSucceeded
+  runFailed
+  runSkipped // because one of the runs it depends on failed
+)
+
+// isDag returns whether the runs declare their dependencies, otherwise they
+// are run one after the other in order
+func isDag(runs []run.Run) bool {
+  for _, r := range runs {
+    if len(r.DependsOn) > 0 {
+      return true
+    }
+  }
+
+  return false
+}
+
+// runDependencies returns the indices of the runs which each run depends on.
+// Without any depends_on, each run depends on the run before it.
+func runDependencies(runs []run.Run) [][]int {
+  deps := make([][]int, len(runs))
+
+  if !isDag(runs) {
+    for i := 1; i < len(runs); i++ {
+      deps[i] = []int{i - 1}
+    }
+    return deps
+  }
+
+  index := make(map[string]int, len(runs))
+  for i, r := range runs {
+    index[r.Name] = i
+  }
+
+  for i, r := range runs {
+    for _, name := range r.DependsOn {
+      if k, ok := index[name]; ok {
+        deps[i] = append(deps[i], k)
+      }
+    }
+  }
+
+  return deps
+}
+
+// checkDependencies returns the problems with the dependencies of the runs
+func (j *Job) checkDependencies() []error {
+  var errs []error
+
+  index := make(map[string]int, len(j.Runs))
+  for i, r := range j.Runs {
+    if _, ok := index[r.Name]; ok {
+      errs = append(errs, fmt.Errorf("Run is defined more than once: %s", r.Name))
+    }
+    index[r.Name] = i
+  }
+
+  for _, r := range j.Runs {
+    for _, name := range r.DependsOn {
+      if name == r.Name {
+        errs = append(errs, fmt.Errorf("Run depends on itself: %s", r.Name))
+      } else if _, ok := index[name]; !ok {
+        errs = append(errs, fmt.Errorf("Run %s depends on unknown run: %s", r.Name, name))
+      }
+    }
+  }
+
+  if len(errs) > 0 {
+    return errs
+  }
+
+  // Look for cycles by following the dependencies depth-first
+  deps := runDependencies(j.Runs)
+  state := make([]int, len(j.Runs)) // 0 unvisited, 1 visiting, 2 visited
+  var path []string
+  var visit func(i int) bool
+  visit = func(i int) bool {
+    if state[i] == 1 {
+      errs = append(errs, fmt.Errorf("Runs depend on each other: %s -> %s",
+        strings.Join(path, " -> "),
+        j.Runs[i].Name,
+      ))
+      return false
+    } else if state[i] == 2 {
+      return true
+    }
+
+    state[i] = 1
+    path = append(path, j.Runs[i].Name)
+    for _, k := range deps[i] {
+      if !visit(k) {
+        return false
+      }
+    }
+    path = path[:len(path) - 1]
+    state[i] = 2
+
+    return true
+  }
+
+  for i := range j.Runs {
+    if !visit(i) {
+      break
+    }
+  }
+
+  return errs
+}
+
+// runInstance is a repetition of one of the runs of a task
+type runInstance struct {
+  index int // of the run in the job
+  rep   int // starting from 1
+}
+
+// repeats returns the number of times the run is repeated, at most
+func repeats(r run.Run) int {
+  if r.Until != nil {
+    return r.Until.Max
+  } else if r.Repeat > 1 {
+    return r.Repeat
+  }
+
+  return 1
+}
+
+// runInstances returns the number of runs of each task including repetitions
+func runInstances(runs []run.Run) int {
+  n := 0
+  for _, r := range runs {
+    n += repeats(r)
+  }
+
+  return n
+}
+
+// overrideRepeat sets the number of repetitions of the runs which are
+// repeated, or of the runs which no other run depends on when none are.  Runs
+// which are repeated until a metric is precise enough are left alone.
+func (j *Job) overrideRepeat(n int) {
+  if n <= 0 {
+    return
+  }
+
+  repeated := false
+  for _, r := range j.Runs {
+    if r.Repeat > 0 {
+      repeated = true
+    }
+  }
+
+  dependents := make([]bool, len(j.Runs))
+  for _, deps := range runDependencies(j.Runs) {
+    for _, d := range deps {
+      dependents[d] = true
+    }
+  }
+
+  for i, r := range j.Runs {
+    if r.Until != nil {
+      continue
+    } else if (repeated && r.Repeat > 0) || (!repeated && !dependents[i]) {
+      j.Runs[i].Repeat = n
+    }
+  }
+}
+
+// succeeded returns whether all repetitions of the run succeeded
+func (t *Task) succeeded(i int) bool {
+  for _, state := range t.states[i] {
+    if state != runSucceeded {
+      return false
+    }
+  }
+
+  return true
+}
+
+// ready returns the runs of the task whose dependencies have all succeeded, in
+// order.  Only the next repetition of a run is ready once the previous one has
+// finished, such that repetitions are spread over time.
+func (t *Task) ready() []runInstance {
+  var ready []runInstance
+
+  for i := range t.runs {
+    deps := true
+    for _, k := range t.deps[i] {
+      if !t.succeeded(k) {
+        deps = false
+      }
+    }
+    if !deps {
+      continue
+    }
+
+    for rep, state := range t.states[i] {
+      if state == runRunning {
+        break
+      } else if state == runPending {
+        ready = append(ready, runInstance{i, rep + 1})
+        break
+      }
+    }
+  }
+
+  return ready
+}
+
+// runIndex returns the index of the run of the task
+func (t *Task) runIndex(name string) int {
+  for i, r := range t.runs {
+    if r.Name == name {
+      return i
+    }
+  }
+
+  return -1
+}
+
+// started marks the repetition of the run as in flight
+func (t *Task) started(ri runInstance) {
+  t.states[ri.index][ri.rep - 1] = runRunning
+}
+
+// completed marks the repetition of the run as finished.  When it failed, the
+// remaining repetitions and the runs which depend on it, directly or
+// indirectly, are skipped and the task is cancelled.
+func (t *Task) completed(ri runInstance, ok bool) {
+  if ok {
+    t.states[ri.index][ri.rep - 1] = runSucceeded
+    return
+  }
+
+  t.states[ri.index][ri.rep - 1] = runFailed
+  t.cancelled = true
+
+  skip := func(k int) {
+    for rep, state := range t.states[k] {
+      if state == runPending {
+        t.states[k][rep] = runSkipped
+      }
+    }
+  }
+  skip(ri.index)
+
+  // Skip dependents until no more runs are affected
+  failed := make([]bool, len(t.runs))
+  failed[ri.index] = true
+  for changed := true; changed; {
+    changed = false
+    for k := range t.runs {
+      if failed[k] {
+        continue
+      }
+
+      for _, d := range t.deps[k] {
+        if failed[d] {
+          log.Warnf("Skipping run %s-%s as it depends on %s", t.UUID(), t.runs[k].Name, t.runs[d].Name)
+          failed[k] = true
+          skip(k)
+          changed = true
+          break
+        }
+      }
+    }
+  }
+}
+
+// stopRepeating drops the repetitions of the run which have not been started
+// and returns how many were dropped
+func (t *Task) stopRepeating(i int) int {
+  k := len(t.states[i])
+  for k > 0 && t.states[i][k - 1] == runPending {
+    k--
+  }
+
+  dropped := len(t.states[i]) - k
+  t.states[i] = t.states[i][:k]
+
+  return dropped
+}
+
+// count returns the number of runs of the task, including repetitions, which
+// are in the state
+func (t *Task) count(state int) int {
+  n := 0
+  for i := range t.states {
+    for _, s := range t.states[i] {
+      if s == state {
+        n++
+      }
+    }
+  }
+
+  return n
+}
+
+// inFlight returns the number of runs of the task which are running
+func (t *Task) inFlight() int {
+  return t.count(runRunning)
+}
+
+// scheduled returns whether any of the runs of the task have been started
+func (t *Task) scheduled() bool {
+  return t.count(runPending) < runInstances(t.runs)
+}
+
+// finished returns whether none of the runs of the task are running or can
+// still be started
+func (t *Task) finished() bool {
+  return t.count(runPending) == 0 && t.count(runRunning) == 0
+}
+
+// outputsDir returns where the outputs of the repetition of the run are saved
+// in the task's results.  Runs are kept apart when they can run at the same
+// time or are repeated.
+func (t *Task) outputsDir(ri runInstance) string {
+  r := t.runs[ri.index]
+  if repeats(r) > 1 {
+    return path.Join(t.resultsDir, r.Name, fmt.Sprintf("%d", ri.rep))
+  } else if t.dag {
+    return path.Join(t.resultsDir, r.Name)
+  }
+
+  return t.resultsDir
+}
+
+// outputsFrom returns where the outputs of the runs which the run depends on,
+// directly or indirectly, are taken from in order
+func (t *Task) outputsFrom(i int) []string {
+  if !t.dag {
+    return []string{t.resultsDir}
+  }
+
+  seen := make([]bool, len(t.runs))
+
+  var visit func(i int)
+  visit = func(i int) {
+    for _, k := range t.deps[i] {
+      if !seen[k] {
+        seen[k] = true
+        visit(k)
+      }
+    }
+  }
+  visit(i)
+
+  var dirs []string
+  for k := range t.runs {
+    if !seen[k] {
+      continue
+    }
+
+    for rep := 1; rep <= len(t.states[k]); rep++ {
+      dirs = append(dirs, t.outputsDir(runInstance{k, rep}))
+    }
+  }
+
+  return dirs
+}
@@ -0,0 +1,120 @@
+package job
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "fmt"
+)
+
+// Explorer is the interface for a configuration space exploration technique.
+// An explorer proposes tasks from the job's parameter space, is informed when
+// each of these tasks has completed and decides when the exploration is done.
+type Explorer interface {
+  // Init prepares the explorer with the job whose space it will explore.
+  Init(job *Job) error
+
+  // Next proposes up to n new tasks to be scheduled.  When n is zero, the
+  // explorer decides by itself how many tasks to propose.
+  Next(n int) ([]*Task, error)
+
+  // Report informs the explorer that a previously proposed task has finished,
+  // either because all of its runs have completed or because it was cancelled.
+  Report(task *Task)
+
+  // Done returns whether the explorer has no more tasks to propose.
+  Done() bool
+}
+
+// NewExplorer returns the exploration technique set in the job's explorer
+// configuration.
+func NewExplorer(cfg *JobExplorer) (Explorer, error) {
+  switch t := cfg.Type; t {
+  case "":
+    return &GridExplorer{}, nil
+  case "grid":
+    return &GridExplorer{}, nil
+  case "random":
+    return NewRandomExplorer(cfg)
+  case "bayesian":
+    return NewBayesianExplorer(cfg)
+  case "genetic":
+    return NewGeneticExplorer(cfg)
+  case "nsga2":
+    return NewNSGA2Explorer(cfg)
+  }
+  return nil, fmt.Errorf("Unknown explorer type: \"%s\"", cfg.Type)
+}
+
+// GridExplorer exhaustively explores the job's parameter space by proposing
+// every possible permutation of its parameters.
+type GridExplorer struct {
+  iter *taskIterator
+  next *Task
+}
+
+// Init prepares to iterate over all the permutations of the job's parameters
+func (e *GridExplorer) Init(job *Job) error {
+  iter, err := job.iterator()
+  if err != nil {
+    return err
+  }
+
+  e.iter = iter
+
+  // Look ahead so that it is known when there are no more permutations
+  e.next, err = e.iter.Next()
+  return err
+}
+
+// Next returns the next n permutations which have not yet been proposed
+func (e *GridExplorer) Next(n int) ([]*Task, error) {
+  var tasks []*Task
+  var err error
+
+  for e.next != nil && (n <= 0 || len(tasks) < n) {
+    tasks = append(tasks, e.next)
+
+    e.next, err = e.iter.Next()
+    if err != nil {
+      return tasks, err
+    }
+  }
+
+  return tasks, nil
+}
+
+// Report is a no-op since the grid does not depend on the outcome of tasks
+func (e *GridExplorer) Report(task *Task) {}
+
+// Done returns whether all permutations have been proposed
+func (e *GridExplorer) Done() bool {
+  return e.next == nil
+}
@@ -0,0 +1,633 @@
+package job
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "fmt"
+  "math"
+  "strconv"
+  "strings"
+  "unicode"
+)
+
+// exprValue is the result of evaluating an expression.  Values are either
+// booleans or strings, where strings which represent numbers can be used in
+// arithmetic and are compared numerically.
+type exprValue struct {
+  str    string
+  isBool bool
+  bool   bool
+}
+
+// number returns the numerical representation of the value
+func (v exprValue) number() (float64, bool) {
+  if v.isBool {
+    return 0, false
+  }
+
+  return parseNumber(v.str)
+}
+
+// parseNumber parses decimal numbers as well as integers with a base prefix,
+// such as the hexadecimal values of Kconfig options, e.g. 0x40.  Values such as
+// "inf" and "nan" are not numbers.
+func parseNumber(s string) (float64, bool) {
+  if num, err := strconv.ParseFloat(s, 64); err == nil {
+    if math.IsInf(num, 0) || math.IsNaN(num) {
+      return 0, false
+    }
+    return num, true
+  }
+
+  if num, err := strconv.ParseInt(s, 0, 64); err == nil {
+    return float64(num), true
+  }
+
+  return 0, false
+}
+
+func (v exprValue) String() string {
+  if v.isBool {
+    return strconv.FormatBool(v.bool)
+  }
+
+  return strconv.Quote(v.str)
+}
+
+// exprNode is a node of the syntax tree of an expression
+type exprNode interface {
+  eval(vars map[string]string) (exprValue, error)
+}
+
+type exprLiteral struct {
+  value exprValue
+}
+
+func (n *exprLiteral) eval(vars map[string]string) (exprValue, error) {
+  return n.value, nil
+}
+
+// exprIdent is a reference to a parameter.  Parameters which are not set
+// evaluate to an empty string.
+type exprIdent struct {
+  name string
+}
+
+func (n *exprIdent) eval(vars map[string]string) (exprValue, error) {
+  return exprValue{str: vars[n.name]}, nil
+}
+
+type exprUnary struct {
+  op      string
+  operand exprNode
+}
+
+func (n *exprUnary) eval(vars map[string]string) (exprValue, error) {
+  v, err := n.operand.eval(vars)
+  if err != nil {
+    return v, err
+  }
+
+  switch n.op {
+  case "!":
+    if !v.isBool {
+      return v, fmt.Errorf("Cannot negate non-boolean value: %s", v)
+    }
+    return exprValue{isBool: true, bool: !v.bool}, nil
+  case "-":
+    num, ok := v.number()
+    if !ok {
+      return v, fmt.Errorf("Cannot negate non-numeric value: %s", v)
+    }
+    return formatNumber(-num), nil
+  }
+
+  return v, fmt.Errorf("Unknown unary operator: %s", n.op)
+}
+
+type exprBinary struct {
+  op    string
+  left  exprNode
+  right exprNode
+}
+
+func (n *exprBinary) eval(vars map[string]string) (exprValue, error) {
+  l, err := n.left.eval(vars)
+  if err != nil {
+    return l, err
+  }
+
+  // Short-circuit boolean operators
+  if n.op == "&&" || n.op == "||" {
+    if !l.isBool {
+      return l, fmt.Errorf("Operand of %s is not boolean: %s", n.op, l)
+    }
+    if (n.op == "&&" && !l.bool) || (n.op == "||" && l.bool) {
+      return l, nil
+    }
+
+    r, err := n.right.eval(vars)
+    if err != nil {
+      return r, err
+    }
+    if !r.isBool {
+      return r, fmt.Errorf("Operand of %s is not boolean: %s", n.op, r)
+    }
+    return r, nil
+  }
+
+  r, err := n.right.eval(vars)
+  if err != nil {
+    return r, err
+  }
+
+  lnum, lok := l.number()
+  rnum, rok := r.number()
+
+  switch n.op {
+  case "==", "!=", "<", "<=", ">", ">=":
+    var cmp int
+    if lok && rok {
+      if lnum < rnum {
+        cmp = -1
+      } else if lnum > rnum {
+        cmp = 1
+      }
+    } else if l.isBool || r.isBool {
+      if n.op != "==" && n.op != "!=" {
+        return l, fmt.Errorf("Cannot order boolean values with %s", n.op)
+      }
+      if l.isBool != r.isBool || l.bool != r.bool {
+        cmp = 1
+      }
+    } else {
+      cmp = strings.Compare(l.str, r.str)
+    }
+
+    var res bool
+    switch n.op {
+    case "==":
+      res = cmp == 0
+    case "!=":
+      res = cmp != 0
+    case "<":
+      res = cmp < 0
+    case "<=":
+      res = cmp <= 0
+    case ">":
+      res = cmp > 0
+    case ">=":
+      res = cmp >= 0
+    }
+
+    return exprValue{isBool: true, bool: res}, nil
+
+  case "+", "-", "*", "/", "%":
+    if !lok || !rok {
+      return l, fmt.Errorf("Cannot apply %s to %s and %s", n.op, l, r)
+    }
+
+    var res float64
+    switch n.op {
+    case "+":
+      res = lnum + rnum
+    case "-":
+      res = lnum - rnum
+    case "*":
+      res = lnum * rnum
+    case "/":
+      if rnum == 0 {
+        return l, fmt.Errorf("Division by zero")
+      }
+      res = lnum / rnum
+    case "%":
+      if rnum == 0 {
+        return l, fmt.Errorf("Division by zero")
+      }
+      res = math.Mod(lnum, rnum)
+    }
+
+    if math.IsInf(res, 0) {
+      return l, fmt.Errorf("Result of %s %s %s is not finite", l, n.op, r)
+    }
+    return formatNumber(res), nil
+  }
+
+  return l, fmt.Errorf("Unknown binary operator: %s", n.op)
+}
+
+// formatNumber returns the value of the number
+func formatNumber(num float64) exprValue {
+  return exprValue{str: strconv.FormatFloat(num, 'g', -1, 64)}
+}
+
+// exprType is the type of an expression as far as it is known before it is
+// evaluated.  Parameters whose values are numbers as well as other strings
+// may be either.
+type exprType int
+
+const (
+  exprAny exprType = iota
+  exprBool
+  exprNumber
+  exprString
+)
+
+func (t exprType) String() string {
+  switch t {
+  case exprBool:
+    return "boolean"
+  case exprNumber:
+    return "number"
+  case exprString:
+    return "string"
+  }
+
+  return "number or string"
+}
+
+// exprCheck checks the operands of each operator have the right type, given
+// the types of the parameters, and returns the type the node evaluates to.
+// Parameters are never boolean.
+func exprCheck(node exprNode, types map[string]exprType) (exprType, error) {
+  switch n := node.(type) {
+  case *exprLiteral:
+    if n.value.isBool {
+      return exprBool, nil
+    }
+    if _, ok := n.value.number(); ok {
+      return exprNumber, nil
+    }
+    return exprString, nil
+
+  case *exprIdent:
+    return types[n.name], nil
+
+  case *exprUnary:
+    operand, err := exprCheck(n.operand, types)
+    if err != nil {
+      return exprAny, err
+    }
+    if n.op == "!" {
+      if operand != exprBool {
+        return exprAny, fmt.Errorf("invalid operand of %s", n.op)
+      }
+      return exprBool, nil
+    }
+    if operand == exprBool || operand == exprString {
+      return exprAny, fmt.Errorf("invalid operand of %s: %s", n.op, operand)
+    }
+    return exprNumber, nil
+
+  case *exprBinary:
+    left, err := exprCheck(n.left, types)
+    if err != nil {
+      return exprAny, err
+    }
+    right, err := exprCheck(n.right, types)
+    if err != nil {
+      return exprAny, err
+    }
+
+    switch n.op {
+    case "&&", "||":
+      if left != exprBool || right != exprBool {
+        return exprAny, fmt.Errorf("operands of %s must be boolean", n.op)
+      }
+      return exprBool, nil
+    case "==", "!=":
+      return exprBool, nil
+    case "<", "<=", ">", ">=":
+      if left == exprBool || right == exprBool {
+        return exprAny, fmt.Errorf("operands of %s cannot be boolean", n.op)
+      }
+      if (left == exprString && right == exprNumber) || (left == exprNumber && right == exprString) {
+        return exprAny, fmt.Errorf("cannot order a %s and a %s with %s", left, right, n.op)
+      }
+      return exprBool, nil
+    default:
+      if left == exprBool || right == exprBool {
+        return exprAny, fmt.Errorf("operands of %s cannot be boolean", n.op)
+      }
+      if left == exprString || right == exprString {
+        return exprAny, fmt.Errorf("operands of %s cannot be strings", n.op)
+      }
+      return exprNumber, nil
+    }
+  }
+
+  return exprAny, fmt.Errorf("unknown expression")
+}
+
+// expression is a compiled boolean expression over the names of parameters,
+// for example `LWIP_NUM_TCPLISTENERS <= LWIP_NUM_TCPCON && LWIP_POOLS == "y"`.
+// It supports the operators `||`, `&&`, `!`, `==`, `!=`, `<`, `<=`, `>`,
+// `>=`, `+`, `-`, `*`, `/` and `%`, parentheses, numbers, quoted strings and
+// the literals `true` and `false`.
+type expression struct {
+  src     string
+  root    exprNode
+  idents  map[string]bool
+}
+
+// compileExpression parses the source of a boolean expression and checks it
+// against the types of the parameters it refers to
+func compileExpression(src string, types map[string]exprType) (*expression, error) {
+  tokens, err := tokenizeExpression(src)
+  if err != nil {
+    return nil, fmt.Errorf("Invalid expression \"%s\": %s", src, err)
+  }
+
+  p := &exprParser{
+    tokens: tokens,
+    idents: make(map[string]bool),
+  }
+
+  root, err := p.parseOr()
+  if err == nil && p.pos < len(p.tokens) {
+    err = fmt.Errorf("unexpected %s", p.tokens[p.pos].text)
+  }
+  if err != nil {
+    return nil, fmt.Errorf("Invalid expression \"%s\": %s", src, err)
+  }
+
+  typ, err := exprCheck(root, types)
+  if err == nil && typ != exprBool {
+    err = fmt.Errorf("not a boolean expression")
+  }
+  if err != nil {
+    return nil, fmt.Errorf("Invalid expression \"%s\": %s", src, err)
+  }
+
+  return &expression{
+    src:    src,
+    root:   root,
+    idents: p.idents,
+  }, nil
+}
+
+// eval evaluates the expression with the values of the parameters
+func (e *expression) eval(params []TaskParam) (bool, error) {
+  vars := make(map[string]string, len(params))
+  for _, param := range params {
+    vars[param.Name] = param.Value
+  }
+
+  v, err := e.root.eval(vars)
+  if err != nil {
+    return false, fmt.Errorf("Could not evaluate \"%s\": %s", e.src, err)
+  }
+
+  if !v.isBool {
+    return false, fmt.Errorf("Expression is not boolean: %s", e.src)
+  }
+
+  return v.bool, nil
+}
+
+// exprToken is a lexical token of an expression
+type exprToken struct {
+  kind string // one of: ident, number, string, op
+  text string
+}
+
+// tokenizeExpression splits the source of an expression into tokens
+func tokenizeExpression(src string) ([]exprToken, error) {
+  var tokens []exprToken
+  runes := []rune(src)
+
+  for i := 0; i < len(runes); {
+    c := runes[i]
+
+    switch {
+    case unicode.IsSpace(c):
+      i++
+
+    case unicode.IsLetter(c) || c == '_':
+      j := i
+      for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_') {
+        j++
+      }
+      tokens = append(tokens, exprToken{"ident", string(runes[i:j])})
+      i = j
+
+    case c == '0' && i + 1 < len(runes) && (runes[i+1] == 'x' || runes[i+1] == 'X'):
+      j := i + 2
+      for j < len(runes) && strings.ContainsRune("0123456789abcdefABCDEF", runes[j]) {
+        j++
+      }
+      text := string(runes[i:j])
+      if _, err := strconv.ParseInt(text, 0, 64); err != nil {
+        return nil, fmt.Errorf("invalid number %s", text)
+      }
+      tokens = append(tokens, exprToken{"number", text})
+      i = j
+
+    case unicode.IsDigit(c) || c == '.':
+      j := i
+      for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.' ||
+          runes[j] == 'e' || runes[j] == 'E' ||
+          ((runes[j] == '-' || runes[j] == '+') && (runes[j-1] == 'e' || runes[j-1] == 'E'))) {
+        j++
+      }
+      text := string(runes[i:j])
+      if _, err := strconv.ParseFloat(text, 64); err != nil {
+        return nil, fmt.Errorf("invalid number %s", text)
+      }
+      tokens = append(tokens, exprToken{"number", text})
+      i = j
+
+    case c == '"' || c == '\'':
+      j := i + 1
+      for j < len(runes) && runes[j] != c {
+        j++
+      }
+      if j >= len(runes) {
+        return nil, fmt.Errorf("unterminated string")
+      }
+      tokens = append(tokens, exprToken{"string", string(runes[i+1:j])})
+      i = j + 1
+
+    default:
+      op := ""
+      if i + 1 < len(runes) {
+        switch two := string(runes[i:i+2]); two {
+        case "||", "&&", "==", "!=", "<=", ">=":
+          op = two
+        }
+      }
+      if op == "" {
+        switch c {
+        case '!', '<', '>', '+', '-', '*', '/', '%', '(', ')':
+          op = string(c)
+        default:
+          return nil, fmt.Errorf("unexpected character %q", c)
+        }
+      }
+      tokens = append(tokens, exprToken{"op", op})
+      i += len(op)
+    }
+  }
+
+  return tokens, nil
+}
+
+// exprParser is a recursive descent parser of expressions
+type exprParser struct {
+  tokens []exprToken
+  pos    int
+  idents map[string]bool
+}
+
+// accept consumes the next token if it is one of the operators
+func (p *exprParser) accept(ops ...string) (string, bool) {
+  if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != "op" {
+    return "", false
+  }
+
+  for _, op := range ops {
+    if p.tokens[p.pos].text == op {
+      p.pos++
+      return op, true
+    }
+  }
+
+  return "", false
+}
+
+// parseBinary parses a left-associative sequence of operands of the next
+// precedence level separated by the operators.
+func (p *exprParser) parseBinary(next func() (exprNode, error), ops ...string) (exprNode, error) {
+  left, err := next()
+  if err != nil {
+    return nil, err
+  }
+
+  for {
+    op, ok := p.accept(ops...)
+    if !ok {
+      return left, nil
+    }
+
+    right, err := next()
+    if err != nil {
+      return nil, err
+    }
+
+    left = &exprBinary{op, left, right}
+  }
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+  return p.parseBinary(p.parseAnd, "||")
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+  return p.parseBinary(p.parseComparison, "&&")
+}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+  left, err := p.parseAdditive()
+  if err != nil {
+    return nil, err
+  }
+
+  op, ok := p.accept("==", "!=", "<=", ">=", "<", ">")
+  if !ok {
+    return left, nil
+  }
+
+  right, err := p.parseAdditive()
+  if err != nil {
+    return nil, err
+  }
+
+  return &exprBinary{op, left, right}, nil
+}
+
+func (p *exprParser) parseAdditive() (exprNode, error) {
+  return p.parseBinary(p.parseMultiplicative, "+", "-")
+}
+
+func (p *exprParser) parseMultiplicative() (exprNode, error) {
+  return p.parseBinary(p.parseUnary, "*", "/", "%")
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+  if op, ok := p.accept("!", "-"); ok {
+    operand, err := p.parseUnary()
+    if err != nil {
+      return nil, err
+    }
+
+    return &exprUnary{op, operand}, nil
+  }
+
+  return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+  if p.pos >= len(p.tokens) {
+    return nil, fmt.Errorf("unexpected end of expression")
+  }
+
+  tok := p.tokens[p.pos]
+  p.pos++
+
+  switch tok.kind {
+  case "number", "string":
+    return &exprLiteral{exprValue{str: tok.text}}, nil
+
+  case "ident":
+    switch tok.text {
+    case "true":
+      return &exprLiteral{exprValue{isBool: true, bool: true}}, nil
+    case "false":
+      return &exprLiteral{exprValue{isBool: true, bool: false}}, nil
+    }
+    p.idents[tok.text] = true
+    return &exprIdent{tok.text}, nil
+
+  case "op":
+    if tok.text == "(" {
+      node, err := p.parseOr()
+      if err != nil {
+        return nil, err
+      }
+      if _, ok := p.accept(")"); !ok {
+        return nil, fmt.Errorf("missing )")
+      }
+      return node, nil
+    }
+  }
+
+  return nil, fmt.Errorf("unexpected %s", tok.text)
+}
@@ -0,0 +1,134 @@
+package job
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "testing"
+)
+
+// TestHexComparison checks that hexadecimal values, such as those of Kconfig
+// hex options, are compared numerically rather than as strings
+func TestHexComparison(t *testing.T) {
+  tests := []struct {
+    src    string
+    value  string
+    expect bool
+  }{
+    {"H < 32", "0x40", false},
+    {"H > 32", "0x40", true},
+    {"H == 64", "0x40", true},
+    {"H == 0x40", "64", true},
+    {"H >= 0x10 && H <= 0xff", "0x20", true},
+    {"H < 0x10", "0x20", false},
+    {"H + 0x10 == 0x50", "0x40", true},
+  }
+
+  for _, test := range tests {
+    e, err := compileExpression(test.src, map[string]exprType{"H": exprNumber})
+    if err != nil {
+      t.Fatalf("Could not compile %s: %s", test.src, err)
+    }
+
+    res, err := e.eval([]TaskParam{{Name: "H", Type: "hex", Value: test.value}})
+    if err != nil {
+      t.Fatalf("Could not evaluate %s: %s", test.src, err)
+    }
+
+    if res != test.expect {
+      t.Errorf("%s with H=%s: got %t, expected %t", test.src, test.value, res, test.expect)
+    }
+  }
+}
+
+// TestExprTypes checks expressions are rejected when their operands cannot
+// have the right type, given the types of the parameters
+func TestExprTypes(t *testing.T) {
+  types := map[string]exprType{
+    "N": exprNumber,
+    "S": exprString,
+    "A": exprAny,
+  }
+
+  tests := []struct {
+    src   string
+    valid bool
+  }{
+    {"N + 1 > 2", true},
+    {"S == 1", true},
+    {"S < \"b\"", true},
+    {"A < 1 && A < \"b\"", true},
+    {"S + 1 > 2", false},
+    {"-S < 0", false},
+    {"S < 1", false},
+    {"N >= \"b\"", false},
+    {"N * \"2\" == 4", true},
+    {"N == \"nan\" || N < \"inf\"", false},
+    {"N + 1", false},
+  }
+
+  for _, test := range tests {
+    _, err := compileExpression(test.src, types)
+    if test.valid && err != nil {
+      t.Errorf("Could not compile %s: %s", test.src, err)
+    } else if !test.valid && err == nil {
+      t.Errorf("Compiled %s, expected a type error", test.src)
+    }
+  }
+}
+
+// TestNonFinite checks values such as "nan" and "inf" are strings rather than
+// numbers, and that arithmetic does not overflow silently
+func TestNonFinite(t *testing.T) {
+  for _, s := range []string{"nan", "NaN", "inf", "-Inf", "1e999"} {
+    if _, ok := parseNumber(s); ok {
+      t.Errorf("Parsed %s as a number", s)
+    }
+  }
+
+  e, err := compileExpression("S == \"nan\"", map[string]exprType{"S": exprString})
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  res, err := e.eval([]TaskParam{{Name: "S", Type: "string", Value: "nan"}})
+  if err != nil || !res {
+    t.Errorf("Got %t, %v for S=nan, expected true", res, err)
+  }
+
+  e, err = compileExpression("N * N > 0", map[string]exprType{"N": exprNumber})
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  if _, err := e.eval([]TaskParam{{Name: "N", Type: "float", Value: "1e300"}}); err == nil {
+    t.Errorf("Evaluated N * N with N=1e300, expected an error")
+  }
+}
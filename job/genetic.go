@@ -0,0 +1,594 @@
+package job
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "fmt"
+  "math"
+  "path"
+  "sort"
+  "time"
+  "io/ioutil"
+  "math/rand"
+  "encoding/json"
+
+  "github.com/lancs-net/wayfinder/log"
+)
+
+// geneticAttempts is the number of times a child is bred before giving up on
+// finding one which satisfies the job's constraints.
+const geneticAttempts = 100
+
+// geneticMember is an individual of a generation whose genome is the index of
+// the value of each of the job's parameters.
+type geneticMember struct {
+  genome  []int
+  task     *Task
+  fitness []float64
+  rank      int
+  crowding  float64
+  evaluated bool
+  proposed  bool
+}
+
+// better returns whether the member is preferred over the other member, first
+// by the Pareto front it belongs to and then by how isolated it is on it.
+func (m *geneticMember) better(other *geneticMember) bool {
+  if m.rank != other.rank {
+    return m.rank < other.rank
+  }
+
+  return m.crowding > other.crowding
+}
+
+// geneticRecord is the serialized form of a member of a generation
+type geneticRecord struct {
+  Task      string             `json:"task"`
+  Params    map[string]string  `json:"params"`
+  Fitness  *float64            `json:"fitness,omitempty"`
+  Metrics   map[string]float64 `json:"metrics,omitempty"`
+  Rank     *int                `json:"rank,omitempty"`
+}
+
+// geneticGeneration is the serialized form of a generation
+type geneticGeneration struct {
+  Generation int             `json:"generation"`
+  Population []geneticRecord `json:"population"`
+}
+
+// GeneticExplorer evolves a population of tasks towards the job's objective by
+// selecting, crossing over and mutating the parameters of the fittest tasks of
+// each generation.  When used as NSGA-II, the population evolves towards the
+// Pareto front of all of the job's objectives instead.
+type GeneticExplorer struct {
+  job          *Job
+  dims       []dimension
+  rand         *rand.Rand
+  seed          int64
+  nsga2         bool
+  populationSize int
+  generations   int
+  mutationRate  float64
+  crossoverRate float64
+  elitism       int
+  objectives  []JobObjective
+  gen           int
+  recorded      bool
+  population  []*geneticMember
+  parents     []*geneticMember
+  fitness       map[string][]float64
+  infeasible    map[string]bool // genomes which were pruned
+  history     []geneticGeneration
+  historyFile   string
+}
+
+// NewGeneticExplorer creates a genetic algorithm explorer with the settings of
+// the explorer configuration.
+func NewGeneticExplorer(cfg *JobExplorer) (*GeneticExplorer, error) {
+  e := &GeneticExplorer{
+    seed:           cfg.Seed,
+    populationSize: cfg.Population,
+    generations:    cfg.Generations,
+    mutationRate:   cfg.MutationRate,
+    crossoverRate:  cfg.CrossoverRate,
+    elitism:        cfg.Elitism,
+  }
+
+  if e.seed == 0 {
+    e.seed = time.Now().UnixNano()
+  }
+  if e.populationSize == 0 {
+    e.populationSize = 20
+  }
+  if e.generations == 0 {
+    e.generations = 10
+  }
+  if e.crossoverRate == 0 {
+    e.crossoverRate = 0.9
+  }
+
+  if e.populationSize < 2 {
+    return nil, fmt.Errorf("Genetic explorer requires a population of at least 2")
+  }
+  if e.generations < 0 {
+    return nil, fmt.Errorf("Invalid number of generations: %d", e.generations)
+  }
+  if e.mutationRate < 0 || e.mutationRate > 1 {
+    return nil, fmt.Errorf("Invalid mutation rate: %g", e.mutationRate)
+  }
+  if e.crossoverRate < 0 || e.crossoverRate > 1 {
+    return nil, fmt.Errorf("Invalid crossover rate: %g", e.crossoverRate)
+  }
+  if e.elitism < 0 || e.elitism >= e.populationSize {
+    return nil, fmt.Errorf("Elitism must be less than the population: %d", e.elitism)
+  }
+
+  return e, nil
+}
+
+// NewNSGA2Explorer creates a genetic algorithm explorer which optimises all of
+// the job's objectives using the non-dominated sorting genetic algorithm.
+func NewNSGA2Explorer(cfg *JobExplorer) (*GeneticExplorer, error) {
+  e, err := NewGeneticExplorer(cfg)
+  if err != nil {
+    return nil, err
+  }
+
+  e.nsga2 = true
+
+  return e, nil
+}
+
+// Init creates the initial population at random
+func (e *GeneticExplorer) Init(job *Job) error {
+  if e.nsga2 {
+    if len(job.Objectives) == 0 {
+      return fmt.Errorf("NSGA-II explorer requires objectives")
+    }
+    e.objectives = job.Objectives
+  } else {
+    objective := job.explorerObjective()
+    if objective == nil {
+      return fmt.Errorf("Genetic explorer requires an objective")
+    }
+    e.objectives = []JobObjective{*objective}
+  }
+
+  for _, objective := range e.objectives {
+    _, err := objective.Maximize()
+    if err != nil {
+      return err
+    }
+  }
+
+  dims, err := job.dimensions()
+  if err != nil {
+    return err
+  }
+
+  e.job = job
+  e.dims = dims
+  e.rand = rand.New(rand.NewSource(e.seed))
+  e.fitness = make(map[string][]float64)
+  e.historyFile = path.Join(job.workDir, "results", "generations.json")
+
+  // Mutate on average one parameter of each child when unset
+  if e.mutationRate == 0 {
+    e.mutationRate = 1 / float64(len(dims))
+  }
+
+  if e.nsga2 {
+    log.Infof("Using NSGA-II explorer with seed %d", e.seed)
+  } else {
+    log.Infof("Using genetic explorer with seed %d", e.seed)
+  }
+
+  var genomes [][]int
+  for i := 0; i < e.populationSize; i++ {
+    var genome []int
+    for attempt := 0; genome == nil; attempt++ {
+      if attempt >= randomAttempts {
+        return fmt.Errorf("Could not find tasks which satisfy the constraints")
+      }
+
+      genome = make([]int, len(dims))
+      for d, dim := range dims {
+        genome[d] = e.rand.Intn(len(dim))
+      }
+
+      ok, err := e.feasible(genome)
+      if err != nil {
+        return err
+      }
+      if !ok {
+        genome = nil
+      }
+    }
+
+    genomes = append(genomes, genome)
+  }
+
+  e.gen = 0
+  e.populate(genomes)
+
+  return nil
+}
+
+// params returns the parameters represented by the genome
+func (e *GeneticExplorer) params(genome []int) []TaskParam {
+  return dimensionParams(e.dims, genome)
+}
+
+// feasible returns whether the genome satisfies the job's constraints.  Genomes
+// which do not are only pruned once, however often they are bred.
+func (e *GeneticExplorer) feasible(genome []int) (bool, error) {
+  key := fmt.Sprint(genome)
+  if e.infeasible[key] {
+    return false, nil
+  }
+
+  ok, err := e.job.feasible(e.params(genome))
+  if err == nil && !ok {
+    if e.infeasible == nil {
+      e.infeasible = make(map[string]bool)
+    }
+    e.infeasible[key] = true
+  }
+
+  return ok, err
+}
+
+// populate replaces the population with members of the provided genomes.
+// Members whose genome has already been evaluated are not proposed again.
+func (e *GeneticExplorer) populate(genomes [][]int) {
+  e.population = nil
+  e.recorded = false
+
+  for _, genome := range genomes {
+    member := &geneticMember{
+      genome: genome,
+      task:   e.job.newTask(e.params(genome)),
+    }
+
+    if fitness, ok := e.fitness[member.task.UUID()]; ok {
+      member.fitness = fitness
+      member.evaluated = true
+      member.proposed = true
+    }
+
+    // Identical members of the same generation share the same task
+    for _, other := range e.population {
+      if other.task.UUID() == member.task.UUID() {
+        member.task = other.task
+        member.proposed = true
+      }
+    }
+
+    e.population = append(e.population, member)
+  }
+}
+
+// complete returns whether every member of the generation has been evaluated
+func (e *GeneticExplorer) complete() bool {
+  for _, member := range e.population {
+    if !member.evaluated {
+      return false
+    }
+  }
+
+  return true
+}
+
+// advance records completed generations and breeds the next generation until
+// the last one has been reached.
+func (e *GeneticExplorer) advance() {
+  for e.complete() {
+    if !e.recorded {
+      e.rank(e.population)
+      e.record()
+    }
+
+    if e.gen + 1 >= e.generations {
+      return
+    }
+
+    e.evolve()
+  }
+}
+
+// rank assigns each member the Pareto front it belongs to and its crowding
+// distance on that front.  With a single objective, this orders the members by
+// their fitness.
+func (e *GeneticExplorer) rank(members []*geneticMember) [][]int {
+  points := make([][]float64, len(members))
+  for i, member := range members {
+    points[i] = member.fitness
+  }
+
+  fronts := nondominatedSort(points)
+  for r, front := range fronts {
+    distance := crowdingDistance(points, front)
+    for i, m := range front {
+      members[m].rank = r
+      members[m].crowding = distance[i]
+    }
+  }
+
+  return fronts
+}
+
+// selectParents chooses the members which survive to become the parents of the next
+// generation.  NSGA-II keeps the best of the previous parents and their
+// offspring, otherwise the whole population are parents.
+func (e *GeneticExplorer) selectParents() {
+  if !e.nsga2 {
+    e.parents = e.population
+    return
+  }
+
+  pool := append([]*geneticMember{}, e.parents...)
+  pool = append(pool, e.population...)
+  fronts := e.rank(pool)
+
+  e.parents = nil
+  for _, front := range fronts {
+    members := make([]*geneticMember, len(front))
+    for i, m := range front {
+      members[i] = pool[m]
+    }
+
+    // Only the most isolated members of the last front which fits survive
+    if len(e.parents) + len(members) > e.populationSize {
+      sort.SliceStable(members, func(i, j int) bool {
+        return members[i].crowding > members[j].crowding
+      })
+      members = members[:e.populationSize - len(e.parents)]
+    }
+
+    e.parents = append(e.parents, members...)
+    if len(e.parents) >= e.populationSize {
+      break
+    }
+  }
+
+  // Rank the survivors amongst themselves for the tournaments
+  e.rank(e.parents)
+}
+
+// tournament selects the better of two random parents
+func (e *GeneticExplorer) tournament() *geneticMember {
+  a := e.parents[e.rand.Intn(len(e.parents))]
+  b := e.parents[e.rand.Intn(len(e.parents))]
+  if b.better(a) {
+    return b
+  }
+
+  return a
+}
+
+// breed creates a child from two parents selected by tournament
+func (e *GeneticExplorer) breed() []int {
+  a := e.tournament()
+  b := e.tournament()
+
+  // Uniform crossover of both parents' genomes
+  child := make([]int, len(a.genome))
+  copy(child, a.genome)
+  if e.rand.Float64() < e.crossoverRate {
+    for d := range child {
+      if e.rand.Intn(2) == 1 {
+        child[d] = b.genome[d]
+      }
+    }
+  }
+
+  // Mutate each gene to a different value of the parameter
+  for d := range child {
+    if len(e.dims[d]) > 1 && e.rand.Float64() < e.mutationRate {
+      child[d] = (child[d] + 1 + e.rand.Intn(len(e.dims[d]) - 1)) % len(e.dims[d])
+    }
+  }
+
+  return child
+}
+
+// evolve breeds the next generation from the current population
+func (e *GeneticExplorer) evolve() {
+  e.selectParents()
+
+  ranked := make([]*geneticMember, len(e.parents))
+  copy(ranked, e.parents)
+  sort.SliceStable(ranked, func(i, j int) bool {
+    return ranked[i].better(ranked[j])
+  })
+
+  var genomes [][]int
+
+  // The fittest members survive unchanged
+  for i := 0; i < e.elitism && i < len(ranked); i++ {
+    genomes = append(genomes, ranked[i].genome)
+  }
+
+  for len(genomes) < e.populationSize {
+    var child []int
+    for attempt := 0; child == nil && attempt < geneticAttempts; attempt++ {
+      child = e.breed()
+
+      ok, err := e.feasible(child)
+      if err != nil {
+        log.Warnf("Could not check constraints of child: %s", err)
+      }
+      if !ok {
+        child = nil
+      }
+    }
+
+    // Fall back to a parent which is known to satisfy the constraints
+    if child == nil {
+      child = e.tournament().genome
+    }
+
+    genomes = append(genomes, child)
+  }
+
+  e.gen++
+  log.Infof("Breeding generation %d/%d", e.gen + 1, e.generations)
+  e.populate(genomes)
+}
+
+// record writes the population and fitness of the generation to the
+// generations file next to the tasks file.
+func (e *GeneticExplorer) record() {
+  generation := geneticGeneration{
+    Generation: e.gen,
+  }
+
+  for _, member := range e.population {
+    params := make(map[string]string)
+    for _, param := range member.task.Params {
+      params[param.Name] = param.Value
+    }
+
+    record := geneticRecord{
+      Task:   member.task.UUID(),
+      Params: params,
+    }
+
+    // Convert the fitness back to the value of each metric
+    metrics := make(map[string]float64)
+    for i, objective := range e.objectives {
+      if math.IsInf(member.fitness[i], 1) {
+        continue
+      }
+
+      val := member.fitness[i]
+      if maximize, _ := objective.Maximize(); maximize {
+        val = -val
+      }
+      metrics[objective.Metric] = val
+    }
+
+    if !e.nsga2 {
+      if fitness, ok := metrics[e.objectives[0].Metric]; ok {
+        record.Fitness = &fitness
+      }
+    } else {
+      rank := member.rank
+      record.Rank = &rank
+      record.Metrics = metrics
+    }
+
+    generation.Population = append(generation.Population, record)
+  }
+
+  e.history = append(e.history, generation)
+  e.recorded = true
+
+  b, err := json.MarshalIndent(e.history, "", "\t")
+  if err != nil {
+    log.Warnf("Could not marshal JSON of generations: %s", err)
+    return
+  }
+
+  log.Debugf("Writing generations file %s...", e.historyFile)
+  err = ioutil.WriteFile(e.historyFile, b, 0644)
+  if err != nil {
+    log.Warnf("Could not write generations file: %s", err)
+  }
+}
+
+// Next proposes up to n members of the current generation which have not yet
+// been proposed.  The next generation is only bred once all members of the
+// current generation have been evaluated.
+func (e *GeneticExplorer) Next(n int) ([]*Task, error) {
+  var tasks []*Task
+
+  e.advance()
+
+  for _, member := range e.population {
+    if n > 0 && len(tasks) >= n {
+      break
+    }
+
+    if !member.proposed {
+      member.proposed = true
+      tasks = append(tasks, member.task)
+    }
+  }
+
+  return tasks, nil
+}
+
+// Report sets the fitness of each member of the generation with the task.  A
+// missing metric results in the worst possible fitness.
+func (e *GeneticExplorer) Report(task *Task) {
+  fitness := make([]float64, len(e.objectives))
+  for i, objective := range e.objectives {
+    val, ok := task.Metrics[objective.Metric]
+    if !ok {
+      log.Warnf("Task %s has no value for %s", task.UUID(), objective.Metric)
+      fitness[i] = math.Inf(1)
+      continue
+    }
+
+    if maximize, _ := objective.Maximize(); maximize {
+      val = -val
+    }
+
+    fitness[i] = val
+  }
+
+  e.fitness[task.UUID()] = fitness
+
+  for _, member := range e.population {
+    if member.task.UUID() == task.UUID() {
+      member.fitness = fitness
+      member.evaluated = true
+    }
+  }
+
+  e.advance()
+}
+
+// Done returns whether every member of the last generation has been proposed
+func (e *GeneticExplorer) Done() bool {
+  if e.gen + 1 < e.generations {
+    return false
+  }
+
+  for _, member := range e.population {
+    if !member.proposed {
+      return false
+    }
+  }
+
+  return true
+}
@@ -0,0 +1,173 @@
+package job
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "fmt"
+  "testing"
+  "math/rand"
+)
+
+// TestGeneticRank checks that members are preferred by their front and then
+// by how isolated they are on it
+func TestGeneticRank(t *testing.T) {
+  e := &GeneticExplorer{}
+
+  var members []*geneticMember
+  for _, point := range paretoPoints {
+    members = append(members, &geneticMember{fitness: point})
+  }
+
+  e.rank(members)
+
+  tests := []struct {
+    a      int
+    b      int
+    expect bool
+  }{
+    {0, 3, true},
+    {3, 0, false},
+    {0, 1, true},
+    {1, 0, false},
+    {5, 4, true},
+  }
+
+  for _, test := range tests {
+    if got := members[test.a].better(members[test.b]); got != test.expect {
+      t.Errorf("Member %d better than %d: got %t, expected %t", test.a, test.b, got, test.expect)
+    }
+  }
+}
+
+// breeder returns an explorer which breeds children with six genes of three
+// values each from the parents
+func breeder(crossoverRate, mutationRate float64, parents ...[]int) *GeneticExplorer {
+  e := &GeneticExplorer{
+    dims:          make([]dimension, 6),
+    rand:          rand.New(rand.NewSource(1)),
+    crossoverRate: crossoverRate,
+    mutationRate:  mutationRate,
+  }
+
+  for d := range e.dims {
+    e.dims[d] = make(dimension, 3)
+  }
+
+  for _, genome := range parents {
+    e.parents = append(e.parents, &geneticMember{genome: genome})
+  }
+
+  return e
+}
+
+// TestGeneticCrossover checks that crossing over takes each gene from either
+// parent
+func TestGeneticCrossover(t *testing.T) {
+  e := breeder(1, 0, []int{0, 0, 0, 0, 0, 0}, []int{1, 1, 1, 1, 1, 1})
+
+  mixed := false
+  for i := 0; i < 20; i++ {
+    child := e.breed()
+
+    genes := make(map[int]bool)
+    for _, gene := range child {
+      genes[gene] = true
+    }
+
+    if genes[2] {
+      t.Errorf("Child %v has a gene which neither parent has", child)
+    }
+    if genes[0] && genes[1] {
+      mixed = true
+    }
+  }
+
+  if !mixed {
+    t.Errorf("No child has genes of both parents")
+  }
+}
+
+// TestGeneticMutation checks that mutating changes a gene to another value
+func TestGeneticMutation(t *testing.T) {
+  e := breeder(0, 1, []int{0, 1, 2, 0, 1, 2})
+
+  for i := 0; i < 20; i++ {
+    child := e.breed()
+    for d, gene := range child {
+      if gene == e.parents[0].genome[d] {
+        t.Errorf("Gene %d of child %v was not mutated", d, child)
+      }
+    }
+  }
+}
+
+// TestGeneticExplorer checks that the same seed proposes the same tasks for
+// each generation, with one or multiple objectives
+func TestGeneticExplorer(t *testing.T) {
+  measure := func(task *Task) map[string]float64 {
+    x, y := paramValue(task, "X"), paramValue(task, "Y")
+    return map[string]float64{
+      "cost":  (x - 5) * (x - 5) + (y - 1) * (y - 1),
+      "speed": x + y,
+    }
+  }
+
+  tests := []struct {
+    explorer   JobExplorer
+    objectives []JobObjective
+  }{
+    {
+      JobExplorer{Type: "genetic", Seed: 7, Population: 4, Generations: 3, Elitism: 1},
+      []JobObjective{{Metric: "cost", Path: "cost.txt"}},
+    },
+    {
+      JobExplorer{Type: "nsga2", Seed: 7, Population: 4, Generations: 3},
+      []JobObjective{
+        {Metric: "cost", Path: "cost.txt"},
+        {Metric: "speed", Path: "speed.txt", Direction: "max"},
+      },
+    },
+  }
+
+  for _, test := range tests {
+    var runs [2][]string
+    for i := range runs {
+      runs[i] = explore(t, explorerJob(t, test.explorer, test.objectives...), measure)
+    }
+
+    if len(runs[0]) == 0 || len(runs[0]) > 12 {
+      t.Errorf("%s: got %d tasks, expected at most 3 generations of 4", test.explorer.Type, len(runs[0]))
+    }
+    if fmt.Sprint(runs[0]) != fmt.Sprint(runs[1]) {
+      t.Errorf("%s: got different tasks with the same seed:\n%v\n%v", test.explorer.Type, runs[0], runs[1])
+    }
+  }
+}
@@ -0,0 +1,254 @@
+package job
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "fmt"
+  "path"
+  "reflect"
+  "strings"
+  "io/ioutil"
+
+  "gopkg.in/yaml.v2"
+
+  "github.com/lancs-net/wayfinder/log"
+  "github.com/lancs-net/wayfinder/run"
+)
+
+// readJobFile decodes a job yaml file and composes it with the job it extends
+// and the files it includes.  The job is nil when it could not be decoded at
+// all.
+func readJobFile(filePath string, chain []string) (*Job, []error) {
+  filePath = path.Clean(filePath)
+  for _, other := range chain {
+    if other == filePath {
+      return nil, []error{fmt.Errorf("Job includes itself: %s", filePath)}
+    }
+  }
+  chain = append(chain, filePath)
+
+  log.Debugf("Reading job configuration: %s", filePath)
+
+  // Slurp the file contents into memory
+  dat, err := ioutil.ReadFile(filePath)
+  if err != nil {
+    return nil, []error{err}
+  }
+
+  if len(dat) == 0 {
+    return nil, []error{fmt.Errorf("File is empty: %s", filePath)}
+  }
+
+  job := &Job{}
+  var errs []error
+
+  // Unknown attributes are reported with their line but do not prevent the
+  // rest of the job from being checked
+  err = yaml.UnmarshalStrict([]byte(dat), job)
+  if typeErr, ok := err.(*yaml.TypeError); ok {
+    for _, msg := range typeErr.Errors {
+      errs = append(errs, fmt.Errorf("%s: %s", filePath, msg))
+    }
+  } else if err != nil {
+    return nil, []error{fmt.Errorf("%s: %s", filePath, err)}
+  }
+
+  // Paths in the files which are pulled in are relative to those files, like
+  // the files they pull in themselves, whereas those of the job are relative
+  // to where wayfinder is run from
+  if len(chain) > 1 {
+    rebaseJob(job, path.Dir(filePath))
+  }
+
+  // The job being extended is the base which everything else overrides,
+  // followed by each included file in order and finally the job itself
+  composed := &Job{}
+  var files []string
+  if len(job.Extends) > 0 {
+    files = append(files, job.Extends)
+  }
+  files = append(files, job.Include...)
+
+  for _, file := range files {
+    if !path.IsAbs(file) {
+      file = path.Join(path.Dir(filePath), file)
+    }
+
+    other, otherErrs := readJobFile(file, chain)
+    errs = append(errs, otherErrs...)
+    if other != nil {
+      mergeJob(composed, other)
+    }
+  }
+
+  mergeJob(composed, job)
+
+  return composed, errs
+}
+
+// rebasePath returns the path relative to the directory, unless it is empty,
+// absolute or starts with a template which may render to an absolute path
+func rebasePath(dir, p string) string {
+  if len(p) == 0 || path.IsAbs(p) || strings.HasPrefix(p, "{{") {
+    return p
+  } else if strings.Contains(p, "{{") {
+    // Cleaning the path could remove parts of the template
+    return dir + "/" + p
+  }
+
+  return path.Join(dir, p)
+}
+
+// rebaseJob makes the sources of the inputs and the source of the parameters
+// of a job which is pulled in relative to the directory of its file
+func rebaseJob(job *Job, dir string) {
+  for i := range job.Inputs {
+    job.Inputs[i].Source = rebasePath(dir, job.Inputs[i].Source)
+  }
+
+  job.ParamsFrom.Path = rebasePath(dir, job.ParamsFrom.Path)
+  job.ParamsFrom.Srctree = rebasePath(dir, job.ParamsFrom.Srctree)
+}
+
+// mergeJob overrides the job with the attributes set in the other job.  Named
+// entries replace those of the same name, scalar attributes replace those which
+// are set and lists of constraints and groups are concatenated.  The explorer,
+// order, source of parameters, objectives and runs are merged attribute by
+// attribute.
+func mergeJob(job *Job, other *Job) {
+  job.Params = mergeParams(job.Params, other.Params)
+  job.Groups = append(job.Groups, other.Groups...)
+  job.Constraints = append(job.Constraints, other.Constraints...)
+
+  mergeFields(&job.ParamsFrom, &other.ParamsFrom)
+  mergeFields(&job.Explorer, &other.Explorer)
+  mergeFields(&job.Order, &other.Order)
+
+  for _, objective := range other.Objectives {
+    i := 0
+    for i < len(job.Objectives) && job.Objectives[i].Metric != objective.Metric {
+      i++
+    }
+    if i < len(job.Objectives) {
+      mergeFields(&job.Objectives[i], &objective)
+    } else {
+      job.Objectives = append(job.Objectives, objective)
+    }
+  }
+
+  // Inputs are identified by where they are placed in the run
+  for _, input := range other.Inputs {
+    i := 0
+    for i < len(job.Inputs) && job.Inputs[i].Destination != input.Destination {
+      i++
+    }
+    if i < len(job.Inputs) {
+      job.Inputs[i] = input
+    } else {
+      job.Inputs = append(job.Inputs, input)
+    }
+  }
+
+  for _, output := range other.Outputs {
+    i := 0
+    for i < len(job.Outputs) && job.Outputs[i].Path != output.Path {
+      i++
+    }
+    if i < len(job.Outputs) {
+      job.Outputs[i] = output
+    } else {
+      job.Outputs = append(job.Outputs, output)
+    }
+  }
+
+  // Runs of the same name are merged attribute by attribute, such that a run
+  // can be used as a template
+  for _, r := range other.Runs {
+    i := 0
+    for i < len(job.Runs) && job.Runs[i].Name != r.Name {
+      i++
+    }
+    if i < len(job.Runs) {
+      job.Runs[i] = mergeRun(job.Runs[i], r)
+    } else {
+      job.Runs = append(job.Runs, r)
+    }
+  }
+}
+
+// mergeRun overrides the attributes of the run which are set in the other run
+func mergeRun(r run.Run, other run.Run) run.Run {
+  mergeFields(&r, &other)
+  return r
+}
+
+// mergeFields overrides the exported attributes of the struct dst points to
+// with those of src which are set.  An attribute which is set to its zero
+// value, e.g. 0, false or "", is indistinguishable from one which is not set
+// and therefore cannot override another value.
+func mergeFields(dst, src interface{}) {
+  d := reflect.ValueOf(dst).Elem()
+  s := reflect.ValueOf(src).Elem()
+
+  for i := 0; i < s.NumField(); i++ {
+    // Skip unexported attributes
+    if len(s.Type().Field(i).PkgPath) > 0 {
+      continue
+    }
+
+    if !s.Field(i).IsZero() {
+      d.Field(i).Set(s.Field(i))
+    }
+  }
+}
+
+// mergeParams combines two sets of parameters, where those of the second set
+// replace those of the first with the same name and the rest are appended.
+func mergeParams(base []JobParam, params []JobParam) []JobParam {
+  var merged []JobParam
+
+  names := make(map[string]int)
+  for _, param := range base {
+    names[param.Name] = len(merged)
+    merged = append(merged, param)
+  }
+
+  for _, param := range params {
+    if i, ok := names[param.Name]; ok {
+      merged[i] = param
+    } else {
+      names[param.Name] = len(merged)
+      merged = append(merged, param)
+    }
+  }
+
+  return merged
+}
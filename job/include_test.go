@@ -0,0 +1,209 @@
+package job
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "os"
+  "path"
+  "strings"
+  "testing"
+  "io/ioutil"
+)
+
+// writeJobFiles writes the job files into a temporary directory, returning it
+func writeJobFiles(t *testing.T, files map[string]string) string {
+  root, err := ioutil.TempDir("", "wayfinder-include")
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  for name, dat := range files {
+    filePath := path.Join(root, name)
+    if err := os.MkdirAll(path.Dir(filePath), os.ModePerm); err != nil {
+      t.Fatal(err)
+    }
+    if err := ioutil.WriteFile(filePath, []byte(dat), 0644); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  return root
+}
+
+// TestIncludeCycle checks that a job which includes itself, directly or
+// indirectly, is reported
+func TestIncludeCycle(t *testing.T) {
+  root := writeJobFiles(t, map[string]string{
+    "job.yaml":        "include: [common/a.yaml]\n",
+    "common/a.yaml":   "include: [b.yaml]\n",
+    "common/b.yaml":   "extends: ../job.yaml\n",
+  })
+  defer os.RemoveAll(root)
+
+  _, errs := readJobFile(path.Join(root, "job.yaml"), nil)
+  if len(errs) != 1 || !strings.Contains(errs[0].Error(), "includes itself") {
+    t.Errorf("Got errors %v, expected the job to include itself", errs)
+  }
+}
+
+// TestIncludeMerge checks that the base job is overridden by the included
+// files in order and then by the job itself, and that the paths of the files
+// which are pulled in are relative to them
+func TestIncludeMerge(t *testing.T) {
+  root := writeJobFiles(t, map[string]string{
+    "jobs/job.yaml": `extends: ../common/base.yaml
+include:
+  - ../common/lib/params.yaml
+  - ../common/lib/override.yaml
+params:
+  - name: B
+    type: int
+    only: [3]
+inputs:
+  - source: job-input
+    destination: /job
+runs:
+  - name: test
+    cmd: ./test.sh
+`,
+    "common/base.yaml": `params:
+  - name: A
+    type: int
+    only: [1]
+  - name: B
+    type: int
+    only: [1]
+explorer:
+  type: random
+  seed: 42
+  budget: 10
+order:
+  policy: shuffle
+  seed: 7
+objectives:
+  - metric: rps
+    path: rps.txt
+    direction: max
+inputs:
+  - source: base-input
+    destination: /base
+  - source: /abs/input
+    destination: /abs
+  - source: "{{ .A }}/input"
+    destination: /templated
+  - source: lib/{{ .A }}/../input
+    destination: /partly-templated
+runs:
+  - name: test
+    image: base-image
+    cmd: ./base.sh
+    cores: 2
+`,
+    "common/lib/params.yaml": `params:
+  - name: B
+    type: int
+    only: [2]
+  - name: C
+    type: int
+    only: [2]
+params_from:
+  type: kconfig
+  path: Kconfig
+constraints:
+  - A < 2
+`,
+    "common/lib/override.yaml": `explorer:
+  budget: 20
+objectives:
+  - metric: rps
+    unit: req/s
+constraints:
+  - B < 4
+`,
+  })
+  defer os.RemoveAll(root)
+
+  job, errs := readJobFile(path.Join(root, "jobs", "job.yaml"), nil)
+  if len(errs) > 0 {
+    t.Fatal(errs)
+  }
+
+  var params []string
+  for _, param := range job.Params {
+    params = append(params, param.Name + "=" + strings.Join(param.Only, ","))
+  }
+  if got := strings.Join(params, " "); got != "A=1 B=3 C=2" {
+    t.Errorf("Got params %s, expected A=1 B=3 C=2", got)
+  }
+
+  if got := strings.Join(job.Constraints, " "); got != "A < 2 B < 4" {
+    t.Errorf("Got constraints %s", got)
+  }
+
+  explorer := JobExplorer{Type: "random", Seed: 42, Budget: 20}
+  if job.Explorer != explorer {
+    t.Errorf("Got explorer %+v, expected %+v", job.Explorer, explorer)
+  }
+
+  if job.Order != (JobOrder{Policy: OrderShuffle, Seed: 7}) {
+    t.Errorf("Got order %+v", job.Order)
+  }
+
+  objective := JobObjective{Metric: "rps", Path: "rps.txt", Unit: "req/s", Direction: "max"}
+  if len(job.Objectives) != 1 || job.Objectives[0] != objective {
+    t.Errorf("Got objectives %+v, expected %+v", job.Objectives, objective)
+  }
+
+  if len(job.Runs) != 1 || job.Runs[0].Image != "base-image" ||
+      job.Runs[0].Cmd != "./test.sh" || job.Runs[0].Cores != 2 {
+    t.Errorf("Got runs %+v", job.Runs)
+  }
+
+  sources := map[string]string{
+    "/base":             path.Join(root, "common", "base-input"),
+    "/abs":              "/abs/input",
+    "/templated":        "{{ .A }}/input",
+    "/partly-templated": path.Join(root, "common") + "/lib/{{ .A }}/../input",
+    "/job":              "job-input",
+  }
+  if len(job.Inputs) != len(sources) {
+    t.Errorf("Got %d inputs, expected %d", len(job.Inputs), len(sources))
+  }
+  for _, input := range job.Inputs {
+    if input.Source != sources[input.Destination] {
+      t.Errorf("Got source %s of %s, expected %s", input.Source, input.Destination, sources[input.Destination])
+    }
+  }
+
+  if p := path.Join(root, "common", "lib", "Kconfig"); job.ParamsFrom.Path != p {
+    t.Errorf("Got params_from path %s, expected %s", job.ParamsFrom.Path, p)
+  }
+}
@@ -0,0 +1,206 @@
+package job
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "fmt"
+)
+
+// taskIterator lazily iterates over the permutations of the job's parameters
+// which meet their conditions and satisfy the job's constraints, in the order
+// the parameters are defined or, with the shuffle order, in a random order.
+type taskIterator struct {
+  job     *Job
+  dims    []dimension
+  values  []dimension // the values of each dimension given the preceding ones
+  index   []int
+  perm    *permutation // of the indexes of the space, when shuffled
+  started bool
+  done    bool
+}
+
+// iterator returns an iterator over all the tasks of the job
+func (j *Job) iterator() (*taskIterator, error) {
+  dims, err := j.dimensions()
+  if err != nil {
+    return nil, err
+  }
+
+  it := &taskIterator{
+    job:    j,
+    dims:   dims,
+    values: make([]dimension, len(dims)),
+    index:  make([]int, len(dims)),
+  }
+
+  if j.Order.Policy == OrderShuffle {
+    size := spaceSize(dims)
+    if size > maxShuffleSize {
+      return nil, fmt.Errorf("Cannot shuffle more than %d tasks", int64(maxShuffleSize))
+    }
+
+    it.perm = newPermutation(uint64(size), j.Order.Seed)
+  }
+
+  return it, nil
+}
+
+// dimensionValues returns the values of the dimension given the preceding
+// parameters.  Conditional parameters whose condition is not met are fixed to
+// their default, or have a single empty value when they are omitted.
+func (j *Job) dimensionValues(dim dimension, prefix []TaskParam) dimension {
+  param := j.param(dim[0][0].Name)
+  if j.active(param, prefix) {
+    return dim
+  }
+
+  if len(param.Default) > 0 {
+    return dimension{{j.inactiveParam(param)}}
+  }
+
+  return dimension{nil}
+}
+
+// prefix returns the parameters of the current values of the dimensions
+// before d
+func (it *taskIterator) prefix(d int) []TaskParam {
+  var params []TaskParam
+  for k := 0; k < d; k++ {
+    params = append(params, it.values[k][it.index[k]]...)
+  }
+
+  return params
+}
+
+// reset moves the dimensions from d onwards back to their first value
+func (it *taskIterator) reset(d int) {
+  for ; d < len(it.dims); d++ {
+    it.values[d] = it.job.dimensionValues(it.dims[d], it.prefix(d))
+    it.index[d] = 0
+  }
+}
+
+// decode moves each dimension to its value at index i of the space, where the
+// last dimension varies fastest.  It returns false if a dimension does not
+// have that value given the preceding ones, e.g. as its condition is not met.
+func (it *taskIterator) decode(i uint64) bool {
+  for d := len(it.dims) - 1; d >= 0; d-- {
+    n := uint64(len(it.dims[d]))
+    it.index[d] = int(i % n)
+    i /= n
+  }
+
+  for d := range it.dims {
+    it.values[d] = it.job.dimensionValues(it.dims[d], it.prefix(d))
+    if it.index[d] >= len(it.values[d]) {
+      return false
+    }
+  }
+
+  return true
+}
+
+// advance moves on to the next permutation, returning false at the end
+func (it *taskIterator) advance() bool {
+  if it.perm != nil {
+    for {
+      i, ok := it.perm.next()
+      if !ok {
+        it.done = true
+        return false
+      }
+
+      if it.decode(i) {
+        return true
+      }
+    }
+  }
+
+  if !it.started {
+    it.started = true
+    it.reset(0)
+    return true
+  }
+
+  for d := len(it.dims) - 1; d >= 0; d-- {
+    it.index[d]++
+    if it.index[d] < len(it.values[d]) {
+      it.reset(d + 1)
+      return true
+    }
+  }
+
+  it.done = true
+  return false
+}
+
+// Next returns the next task, or nil once all permutations have been iterated
+func (it *taskIterator) Next() (*Task, error) {
+  for !it.done && it.advance() {
+    params := it.prefix(len(it.dims))
+
+    ok, err := it.job.feasible(params)
+    if err != nil {
+      return nil, err
+    }
+    if ok {
+      return it.job.newTask(params), nil
+    }
+  }
+
+  return nil, nil
+}
+
+// countTasks walks all of the tasks of the job and returns how many satisfy
+// the constraints and how many were pruned, without recording the latter
+func (j *Job) countTasks() (int, int, error) {
+  iter, err := j.iterator()
+  if err != nil {
+    return 0, 0, err
+  }
+
+  j.pruned = 0
+  count := 0
+  for {
+    task, err := iter.Next()
+    j.prunedQueue = nil
+    if err != nil {
+      return 0, 0, err
+    }
+    if task == nil {
+      break
+    }
+
+    count++
+  }
+
+  return count, j.pruned, nil
+}
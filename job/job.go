@@ -38,12 +38,9 @@ import (
   "sync"
   "path"
   "strconv"
-  "io/ioutil"
+  "strings"
   "encoding/json"
 
-  "gopkg.in/yaml.v2"
-  "github.com/novln/docker-parser"
-
   "github.com/lancs-net/wayfinder/log"
   "github.com/lancs-net/wayfinder/run"
 )
@@ -51,24 +48,64 @@ import (
 type JobParam struct {
   Name      string `yaml:"name"`
   Type      string `yaml:"type"`
-  Default   string `yaml:"default"`
-  Only    []string `yaml:"only"`
-  Min       string `yaml:"min"`
-  Max       string `yaml:"max"`
-  Step      string `yaml:"step"`
-  StepMode  string `yaml:"step_mode"`
+  Default   string `yaml:"default,omitempty"`
+  Only    []string `yaml:"only,omitempty,flow"`
+  Min       string `yaml:"min,omitempty"`
+  Max       string `yaml:"max,omitempty"`
+  Step      string `yaml:"step,omitempty"`
+  StepMode  string `yaml:"step_mode,omitempty"`
+  Count     string `yaml:"count,omitempty"`
+  When      string `yaml:"when,omitempty"`
+}
+
+// JobExplorer selects and configures the technique used to explore the job's
+// parameter space.
+type JobExplorer struct {
+  Type          string  `yaml:"type"`
+  Seed          int64   `yaml:"seed"`
+  Budget        int     `yaml:"budget"`
+  Initial       int     `yaml:"initial"`
+  Population    int     `yaml:"population"`
+  Generations   int     `yaml:"generations"`
+  MutationRate  float64 `yaml:"mutation_rate"`
+  CrossoverRate float64 `yaml:"crossover_rate"`
+  Elitism       int     `yaml:"elitism"`
+  Objective     string  `yaml:"objective"`
 }
 
 type Job struct {
-  Params        []JobParam   `yaml:"params"`
-  Inputs        []run.Input  `yaml:"inputs"`
-  Outputs       []run.Output `yaml:"outputs"`
-  Runs          []run.Run    `yaml:"runs"`
+  Extends         string       `yaml:"extends"`
+  Include       []string       `yaml:"include"`
+  Params        []JobParam     `yaml:"params"`
+  Groups        [][]string     `yaml:"groups"`
+  ParamsFrom    JobParamsFrom  `yaml:"params_from"`
+  Explorer      JobExplorer    `yaml:"explorer"`
+  Order         JobOrder       `yaml:"order"`
+  Objectives    []JobObjective `yaml:"objectives"`
+  Constraints   []string       `yaml:"constraints"`
+  Inputs        []run.Input    `yaml:"inputs"`
+  Outputs       []run.Output   `yaml:"outputs"`
+  Runs          []run.Run      `yaml:"runs"`
   waitList     *List
   scheduleGrace int
   dryRun        bool
   bridge       *run.Bridge
   maxRetries    int
+  workDir       string
+  allowOverride bool
+  explorer      Explorer
+  exploreLock   sync.Mutex
+  best          map[string]bestTask // by objective
+  front       []paretoRecord // of the measured tasks
+  constraints []*expression
+  conditions    map[string]*expression
+  pruned        int // number of tasks pruned by constraints
+  prunedQueue []prunedTask
+  memory        int64
+  timeout       time.Duration // of runs which do not set one
+  killGrace     time.Duration
+  digests       map[string]string
+  inputHashes   map[string]string
 }
 
 // RuntimeConfig contains details about the runtime of wayfinder
@@ -81,6 +118,12 @@ type RuntimeConfig struct {
   WorkDir         string
   AllowOverride   bool
   MaxRetries      int
+  SysfsRoot       string
+  ReserveSiblings bool
+  Memory          int64 // bytes of memory available to runs, 0 for all
+  Repeat          int   // repetitions of the repeated runs, 0 as in the job
+  Timeout         time.Duration // of runs which do not set one, 0 for none
+  KillGrace       time.Duration // between stopping and killing a timed out run
 }
 
 // tasksInFlight represents the maximum tasks which are actively running
@@ -90,110 +133,68 @@ var tasksInFlight *CoreMap
 
 // NewJob prepares a job yaml file
 func NewJob(filePath string, cfg *RuntimeConfig, dryRun bool) (*Job, error) {
-  // Check if the path is set
-  if len(filePath) == 0 {
-    return nil, fmt.Errorf("File path cannot be empty")
-  }
-
-  // Check if the file exists
-  if _, err := os.Stat(filePath); os.IsNotExist(err) {
-    return nil, fmt.Errorf("File does not exist: %s", filePath)
+  job, errs := loadJob(filePath)
+  if job == nil {
+    return nil, joinErrors(errs)
   }
 
-  log.Debugf("Reading job configuration: %s", filePath)
+  // Create a list with all the tasks waiting
+  job.waitList = NewList(0)
 
-  // Slurp the file contents into memory
-  dat, err := ioutil.ReadFile(filePath)
-  if err != nil {
-    return nil, err
-  }
+  // Set the schedule grace time
+  job.scheduleGrace = cfg.ScheduleGrace
 
-  if len(dat) == 0 {
-    return nil, fmt.Errorf("File is empty")
+  job.dryRun = dryRun
+  job.maxRetries = cfg.MaxRetries
+  job.workDir = cfg.WorkDir
+  job.allowOverride = cfg.AllowOverride
+  job.timeout = cfg.Timeout
+  job.killGrace = cfg.KillGrace
+  job.overrideRepeat(cfg.Repeat)
+
+  // Check the job is well-formed and prepare its explorer
+  errs = append(errs, job.validate(cfg)...)
+  if len(errs) > 0 {
+    return nil, joinErrors(errs)
   }
 
-  job := Job{}
-
-  err = yaml.Unmarshal([]byte(dat), &job)
+  // Tasks are generated on demand so only the size of the space is known
+  dims, err := job.dimensions()
   if err != nil {
     return nil, err
   }
 
-  log.Info("Calculating number of tasks...")
-
-  if len(job.Params) == 0 {
-    return nil, fmt.Errorf("You have not set any parameters")
-  }
+  log.Infof("There are at most %d tasks before constraints", spaceSize(dims))
 
-  // Create all tasks for job, iterating over all possible parameter 
-  // permutations
-  tasks, err := job.tasks()
+  // Look up the digest of each image once rather than whilst exploring
+  err = job.resolveDigests()
   if err != nil {
     return nil, err
   }
 
-  // Write a tasks file containing all the permutations
-  tasksJson := make(map[string]interface{})
-  for _, task := range tasks {
-    params := make(map[string]string)
-    for _, param := range task.Params {
-      params[param.Name] = param.Value
-    }
-    tasksJson[task.UUID()] = params
-  }
-
-  b, err := json.MarshalIndent(tasksJson, "", "\t")
-  if err != nil {
-    return nil, fmt.Errorf("Could not marshal JSON of tasks: %s", err)
-  }
-
-  tasksJsonFile := path.Join(cfg.WorkDir, "results", "tasks.json")
-  log.Debugf("Writing tasks file %s...", tasksJsonFile)
-  err = ioutil.WriteFile(tasksJsonFile, b, 0644)
+  // Allocate cores to runs according to where they are located
+  topology, err := job.readTopology(cfg)
   if err != nil {
-    return nil, fmt.Errorf("Could not write tasks file: %s", err)
+    return nil, err
   }
 
-  // Create a list with all the tasks waiting
-  job.waitList = NewList(len(tasks))
-
-  // Set the schedule grace time
-  job.scheduleGrace = cfg.ScheduleGrace
-
-  job.dryRun = dryRun
-  job.maxRetries = cfg.MaxRetries
-
-  // Iterate over all the tasks, check if the run is stasifyable, initialize the
-  // task and add it to the waiting list.
-  for _, task := range tasks {
-    for i, run := range job.Runs {
-      // Check if this particular run has requested more cores than what is
-      if run.Cores > len(cfg.Cpus) {
-        return nil, fmt.Errorf(
-          "Run has too many cores: %s: %d > %d",
-          run.Name,
-          run.Cores,
-          len(cfg.Cpus),
-        )
-
-      // Set the default number of cores to use
-      } else if run.Cores == 0 {
-        job.Runs[i].Cores = 1
-      }
-    }
-
-    err := task.Init(cfg.WorkDir, cfg.AllowOverride, &job.Runs, dryRun)
+  // Memory is allocated to the runs which set a limit on it
+  job.memory = cfg.Memory
+  if job.memory == 0 {
+    job.memory, err = hostMemory()
     if err != nil {
-      log.Errorf("Could not initialize task: %s", err)
-    } else {
-      job.waitList.Add(task)
+      log.Warnf("Memory will not be allocated to runs: %s", err)
     }
   }
 
-  log.Infof("There are total %d tasks", job.waitList.Len())
+  for _, r := range job.Runs {
+    if memory := runMemory(r); job.memory > 0 && memory > job.memory {
+      return nil, fmt.Errorf("Run %s requires %s of memory but only %s is available", r.Name, r.Memory, formatSize(job.memory))
+    }
+  }
 
   // Prepare a map of cores to hold onto a particular task's run
-  tasksInFlight = NewCoreMap(cfg.Cpus)
+  tasksInFlight = NewCoreMap(cfg.Cpus, topology, cfg.ReserveSiblings)
 
   // Set up the bridge
   job.bridge = &run.Bridge{
@@ -207,7 +208,29 @@ func NewJob(filePath string, cfg *RuntimeConfig, dryRun bool) (*Job, error) {
     return nil, fmt.Errorf("Could not create bridge: %s", err)
   }
 
-  return &job, nil
+  return job, nil
+}
+
+// readTopology reads the topology of the CPUs the job runs on and checks each
+// of its runs can be allocated enough of them
+func (j *Job) readTopology(cfg *RuntimeConfig) (*Topology, error) {
+  sysfsRoot := cfg.SysfsRoot
+  if sysfsRoot == "" {
+    sysfsRoot = "/sys"
+  }
+
+  topology, err := ReadTopology(sysfsRoot, cfg.Cpus)
+  if err != nil {
+    return nil, fmt.Errorf("Could not read CPU topology: %s", err)
+  }
+
+  for _, r := range j.Runs {
+    if capacity := topology.capacity(cfg.ReserveSiblings); r.Cores > capacity {
+      return nil, fmt.Errorf("Run %s requires %d cores but only %d can be allocated", r.Name, r.Cores, capacity)
+    }
+  }
+
+  return topology, nil
 }
 
 // parseParamInt attends to string parameters and its possible permutations
@@ -233,6 +256,26 @@ func parseParamStr(param *JobParam) ([]TaskParam, error) {
   return params, nil
 }
 
+// parseInt parses the value of an integer parameter, where hex parameters may
+// optionally be prefixed with 0x.
+func parseInt(param *JobParam, val string) (int, error) {
+  if param.Type == "hex" {
+    num, err := strconv.ParseInt(strings.TrimPrefix(strings.ToLower(val), "0x"), 16, 64)
+    return int(num), err
+  }
+
+  return strconv.Atoi(val)
+}
+
+// formatInt formats the value of an integer parameter
+func formatInt(param *JobParam, val int) string {
+  if param.Type == "hex" {
+    return fmt.Sprintf("0x%x", val)
+  }
+
+  return strconv.Itoa(val)
+}
+
 // parseParamInt attends to integer parameters and its possible permutations
 func parseParamInt(param *JobParam) ([]TaskParam, error) {
   var params []TaskParam
@@ -249,12 +292,12 @@ func parseParamInt(param *JobParam) ([]TaskParam, error) {
 
   // Parse range between min and max
   } else if len(param.Min) > 0 {
-    min, err := strconv.Atoi(param.Min)
+    min, err := parseInt(param, param.Min)
     if err != nil {
       return nil, err
     }
     
-    max, err := strconv.Atoi(param.Max)
+    max, err := parseInt(param, param.Max)
     if err != nil {
       return nil, err
     }
@@ -268,8 +311,8 @@ func parseParamInt(param *JobParam) ([]TaskParam, error) {
     // Figure out the step
     step := 1
     if len(param.Step) > 0 {
-      step, err = strconv.Atoi(param.Step)
-      if err != nil || step == 0 {
+      step, err = parseInt(param, param.Step)
+      if err != nil || step <= 0 {
         return nil, fmt.Errorf(
           "Invalid step for %s: %s", param.Name, param.Step,
         )
@@ -282,17 +325,23 @@ func parseParamInt(param *JobParam) ([]TaskParam, error) {
         params = append(params, TaskParam{
           Name:  param.Name,
           Type:  param.Type,
-          Value: strconv.Itoa(i),
+          Value: formatInt(param, i),
         })
       }
 
     // Use exponential step
     } else if param.StepMode == "power" {
+      if step < 2 {
+        return nil, fmt.Errorf(
+          "Invalid step for %s: power requires a step > 1", param.Name,
+        )
+      }
+
       for i, j := min, min; i <= max; j++ {
         params = append(params, TaskParam{
           Name:  param.Name,
           Type:  param.Type,
-          Value: strconv.Itoa(i),
+          Value: formatInt(param, i),
         })
         i = int(math.Pow(float64(step), float64(j)))
       }
@@ -318,6 +367,161 @@ func parseParamInt(param *JobParam) ([]TaskParam, error) {
   return params, nil
 }
 
+// floatPrecision is the number of significant digits float parameters are
+// rounded to, such that the same value is always formatted the same way
+// regardless of rounding errors in how it was computed.
+const floatPrecision = 12
+
+// formatFloat formats the value of a float parameter
+func formatFloat(val float64) string {
+  rounded, err := strconv.ParseFloat(
+    strconv.FormatFloat(val, 'g', floatPrecision, 64), 64,
+  )
+  if err != nil {
+    rounded = val
+  }
+
+  return strconv.FormatFloat(rounded, 'g', -1, 64)
+}
+
+// parseParamFloat attends to float parameters and its possible permutations
+func parseParamFloat(param *JobParam) ([]TaskParam, error) {
+  var params []TaskParam
+  var values []float64
+
+  // Parse values in only
+  if len(param.Only) > 0 {
+    for _, val := range param.Only {
+      num, err := strconv.ParseFloat(val, 64)
+      if err != nil {
+        return nil, fmt.Errorf(
+          "Invalid value for %s: %s", param.Name, val,
+        )
+      }
+      values = append(values, num)
+    }
+
+  // Parse range between min and max
+  } else if len(param.Min) > 0 {
+    min, err := strconv.ParseFloat(param.Min, 64)
+    if err != nil {
+      return nil, err
+    }
+
+    max, err := strconv.ParseFloat(param.Max, 64)
+    if err != nil {
+      return nil, err
+    }
+
+    if max < min {
+      return nil, fmt.Errorf(
+        "Min can't be greater than max for %s: %s < %s", param.Name, param.Min, param.Max,
+      )
+    }
+
+    switch param.StepMode {
+    // Use iterative step
+    case "", "increment":
+      step := 1.0
+      if len(param.Step) > 0 {
+        step, err = strconv.ParseFloat(param.Step, 64)
+        if err != nil || step <= 0 {
+          return nil, fmt.Errorf(
+            "Invalid step for %s: %s", param.Name, param.Step,
+          )
+        }
+      }
+
+      // Compute each value from the start to avoid accumulating errors
+      n := int(math.Floor((max - min) / step + 1e-9))
+      for i := 0; i <= n; i++ {
+        values = append(values, min + float64(i) * step)
+      }
+
+    // Use exponential step
+    case "power":
+      step, err := strconv.ParseFloat(param.Step, 64)
+      if err != nil || step <= 1 || min <= 0 {
+        return nil, fmt.Errorf(
+          "Invalid step for %s: power requires a step > 1 and min > 0", param.Name,
+        )
+      }
+
+      for i := 0; ; i++ {
+        val := min * math.Pow(step, float64(i))
+        if val > max * (1 + 1e-9) {
+          break
+        }
+        values = append(values, val)
+      }
+
+    // Use a fixed number of evenly spaced values
+    case "linspace", "logspace":
+      count, err := strconv.Atoi(param.Count)
+      if err != nil || count < 1 {
+        return nil, fmt.Errorf(
+          "Invalid count for %s: %s", param.Name, param.Count,
+        )
+      }
+
+      if param.StepMode == "logspace" && min <= 0 {
+        return nil, fmt.Errorf(
+          "Min must be greater than zero for logspace of %s: %s", param.Name, param.Min,
+        )
+      }
+
+      for i := 0; i < count; i++ {
+        frac := 0.0
+        if count > 1 {
+          frac = float64(i) / float64(count - 1)
+        }
+
+        if param.StepMode == "linspace" {
+          values = append(values, min + frac * (max - min))
+        } else {
+          values = append(values, min * math.Pow(max / min, frac))
+        }
+      }
+
+    // Unknown step mode
+    default:
+      return nil, fmt.Errorf(
+        "Unknown step mode for param %s: %s", param.Name, param.StepMode,
+      )
+    }
+
+  } else if len(param.Default) > 0 {
+    num, err := strconv.ParseFloat(param.Default, 64)
+    if err != nil {
+      return nil, fmt.Errorf(
+        "Invalid default for %s: %s", param.Name, param.Default,
+      )
+    }
+    values = append(values, num)
+
+  } else {
+    log.Warnf("Parameter not parsed: %s", param.Name)
+  }
+
+  // Format values consistently and drop those which collapse into one another
+  seen := make(map[string]bool)
+  for _, val := range values {
+    str := formatFloat(val)
+    if seen[str] {
+      continue
+    }
+    seen[str] = true
+
+    params = append(params, TaskParam{
+      Name:  param.Name,
+      Type:  param.Type,
+      Value: str,
+    })
+  }
+
+  return params, nil
+}
+
 // paramPermutations discovers all the possible variants of a particular
 // parameter based on its type and options.
 func paramPermutations(param *JobParam) ([]TaskParam, error) {
@@ -328,265 +532,246 @@ func paramPermutations(param *JobParam) ([]TaskParam, error) {
     return parseParamInt(param)
   case "integer":
     return parseParamInt(param)
+  case "hex":
+    return parseParamInt(param)
+  case "float":
+    return parseParamFloat(param)
   }
   return nil, fmt.Errorf(
     "Unknown parameter type: \"%s\" for %s", param.Type, param.Name,
   )
 }
 
-// nextTask recursively iterates across paramters to generate a set of tasks
-func (j *Job) nextTask(i int, tasks []*Task, curr []TaskParam) ([]*Task, error) {
-  // List all permutations for this parameter
-  params, err := paramPermutations(&j.Params[i])
-  if err != nil {
-    return nil, err
+// newTask creates a task for the job with a copy of the provided parameters,
+// leaving out those whose condition is not met.
+func (j *Job) newTask(params []TaskParam) *Task {
+  p := j.resolve(params)
+
+  return &Task{
+    Inputs:  &j.Inputs,
+    Outputs: &j.Outputs,
+    Params:   p,
   }
+}
 
-  for _, param := range params {
-    if len(curr) > 0 {
-      last := curr[len(curr)-1]
-      if last.Name == param.Name {
-        curr = curr[:len(curr)-1]
-      }
+// dimension holds the possible values of a parameter, or of a group of
+// parameters which vary together, where each value assigns all of them.
+type dimension [][]TaskParam
+
+// dimensions returns all the possible values of each of the job's parameters,
+// in the same order as the parameters are defined.  Grouped parameters are
+// zipped into a single dimension at the position of the group's first member.
+func (j *Job) dimensions() ([]dimension, error) {
+  var dims []dimension
+
+  groups := make(map[string][]string)
+  for _, group := range j.Groups {
+    for _, name := range group {
+      groups[name] = group
     }
+  }
 
-    curr = append(curr, param)
-
-    // Break when there are no more parameters to iterate over, thus creating
-    // the task.
-    if i + 1 == len(j.Params) {
-      var p = make([]TaskParam, len(j.Params))
-      copy(p, curr)
-      task := &Task{
-        Inputs:  &j.Inputs,
-        Outputs: &j.Outputs,
-        Params:   p,
-      }
-      tasks = append(tasks, task)
+  done := make(map[string]bool)
 
-    // Otherwise, recursively parse parameters in-order    
-    } else {
-      nextTasks, err := j.nextTask(i + 1, nil, curr)
+  for i := range j.Params {
+    if done[j.Params[i].Name] {
+      continue
+    }
+
+    members, grouped := groups[j.Params[i].Name]
+    if !grouped {
+      members = []string{j.Params[i].Name}
+    }
+
+    var dim dimension
+    for _, name := range members {
+      params, err := paramPermutations(j.param(name))
       if err != nil {
         return nil, err
       }
 
-      tasks = append(tasks, nextTasks...)
+      if len(params) == 0 {
+        return nil, fmt.Errorf("Parameter has no values: %s", name)
+      }
+
+      if dim == nil {
+        dim = make(dimension, len(params))
+      } else if len(params) != len(dim) {
+        return nil, fmt.Errorf(
+          "Grouped parameters must have the same number of values: %s has %d, %s has %d",
+          members[0], len(dim), name, len(params),
+        )
+      }
+
+      for k, param := range params {
+        dim[k] = append(dim[k], param)
+      }
+
+      done[name] = true
     }
+
+    dims = append(dims, dim)
   }
 
-  return tasks, nil
+  return dims, nil
 }
 
-// tasks returns a list of all possible tasks based on parameterisation
-func (j *Job) tasks() ([]*Task, error) {
-  var tasks []*Task
-
-  tasks, err := j.nextTask(0, tasks, nil)
-  if err != nil {
-    return nil, err
+// dimensionParams returns the parameters at the index of each dimension's value
+func dimensionParams(dims []dimension, point []int) []TaskParam {
+  var params []TaskParam
+  for d, i := range point {
+    params = append(params, dims[d][i]...)
   }
 
-  return tasks, nil
+  return params
 }
 
-// Start the job and all of its tasks
-func (j *Job) Start() error {
-  var freeCores []int
-  var wg sync.WaitGroup
-
-  // Pre-emptively pull all images
-  for _, r := range j.Runs {
-    ref, err := dockerparser.Parse(r.Image)
-    if err != nil {
-      return fmt.Errorf("Could not parse image: %s", err)
+// spaceSize returns the total number of permutations of the provided
+// dimensions, saturating at math.MaxInt64.
+func spaceSize(dims []dimension) int64 {
+  var size int64 = 1
+  for _, dim := range dims {
+    if size > math.MaxInt64 / int64(len(dim)) {
+      return math.MaxInt64
     }
+    size *= int64(len(dim))
+  }
 
-    log.Infof("Pulling %s...", ref.Remote())
+  return size
+}
 
-    _, err = run.PullImage(ref.Remote(), j.bridge.CacheDir)
-    if err != nil {
-      return fmt.Errorf("Could not pull image: %s", err)
-    }
+// explore requests up to n new tasks from the explorer, initializes them and
+// adds them to the wait list.  It returns the number of tasks which were added
+// and the number which were proposed by the explorer.
+func (j *Job) explore(n int) (int, int, error) {
+  j.exploreLock.Lock()
+  defer j.exploreLock.Unlock()
+
+  tasks, err := j.explorer.Next(n)
+  if err != nil {
+    return 0, 0, fmt.Errorf("Could not retrieve tasks from explorer: %s", err)
   }
 
-  curTaskNum := 0
-  totalTasks := j.waitList.Len() * len(j.Runs)
+  if len(tasks) == 0 {
+    return 0, 0, nil
+  }
 
-  // Continuously iterate over the wait list and the queue of the task to
-  // determine whether there is space for the task's run to be scheduled
-  // on the available list of cores.
-  for i := 0; j.waitList.Len() > 0; {
-    // Continiously updates the number of available cores free so this
-    // particular task's run so we can decide whether to schedule it.
-    freeCores = tasksInFlight.FreeCores()
-    if len(freeCores) == 0 {
+  added := 0
+  for _, task := range tasks {
+    // Guard against explorers proposing tasks which should have been pruned
+    ok, err := j.feasible(task.Params)
+    if err != nil {
+      return added, len(tasks), err
+    }
+    if !ok {
+      j.explorer.Report(task)
       continue
     }
 
-    // Get the next task from the job's queue
-    task, err := j.waitList.Get(i)
+    task.manifest, err = j.newManifest(task)
     if err != nil {
-      i = 0 // jump back to task 0 in case we overflow
-      log.Errorf("Could not get task from wait list: %s", err)
+      log.Errorf("Could not evaluate task %s: %s", task.UUID(), err)
+      j.explorer.Report(task)
       continue
     }
 
-    // Without removing an in-order run from the queue, peak at it so we can
-    // determine whether it is schedulable based on the number of cores which
-    // are available.
-    nextRun, err := task.(*Task).runs.Peak()
-    if err != nil {
-      log.Errorf("Could not peak next run for task: %d: %s", i, err)
-
-    // Can we schedule this run?  Use an else if here so we don't ruin the
-    // ordering of the iterator `i`
-    } else if len(freeCores) >= nextRun.(run.Run).Cores {
-      // Check if the peaked run is currently active
-      tasksInFlight.RLock()
-      for _, atr := range tasksInFlight.All() {
-        if atr != nil {
-          if atr.Task.UUID() == task.(*Task).UUID() {
-            tasksInFlight.RUnlock()
-            goto iterator
-          }
-        }
-      }
-      tasksInFlight.RUnlock()
-
-      // Select some core IDs for this run based on how many it requires
-      var cores []int
-      for j := 0; j < nextRun.(run.Run).Cores; j++ {
-        cores = append(cores, freeCores[len(freeCores)-1])
-        freeCores = freeCores[:len(freeCores)-1]
-      }
-
-      // Initialize the task run
-      activeTaskRun, err := NewActiveTaskRun(
-        task.(*Task),
-        nextRun.(run.Run),
-        cores,
-        j.bridge,
-        j.dryRun,
-        j.maxRetries,
-      )
-      if err != nil {
-        log.Errorf("Could not initialize run for this task: %s", err)
-
-        // By cancelling all the subsequent runs, the task will be removed from 
-        // scheduler.
-        task.(*Task).Cancel()
-        goto iterator
-      }
-
-      curTaskNum++
-      log.Infof("Scheduling task run %s (%d/%d)...",
-        activeTaskRun.UUID(),
-        curTaskNum,
-        totalTasks,
-      )
-
-      // Finally, we can dequeue the run since we are about to schedule it
-      nextRun, err = task.(*Task).runs.Dequeue()
-
-      // Add the active task to the list of utilised cores
-      j := 1
-      for len(cores) > 0 {
-        coreId := cores[len(cores)-j]
-        err := tasksInFlight.Set(coreId, activeTaskRun)
-        if err != nil {
-          log.Warnf("Could not schedule task on core ID %d: %s", coreId, err)
-
-          // Use an offset to be able to skip over unavailable cores
-          if j >= len(cores) {
-            j = 1
-          } else {
-            j = j + 1
-          }
-          continue
-        }
-
-        // If we are able to use the core, remove it from the list
-        cores = cores[:len(cores)-j]
-      }
+    err = task.Init(j.workDir, j.allowOverride, &j.Runs, j.dryRun)
+    if err == errTaskComplete {
+      log.Infof("Reusing results of task %s", task.UUID())
+      j.measure(task)
+      j.explorer.Report(task)
+    } else if err != nil {
+      log.Errorf("Could not initialize task: %s", err)
 
-      // Create a thread where we oversee the runtime of this task's run.  By
-      // starting this run, it will decide how to consume the cores we have
-      // provided to it.
-      wg.Add(1) // Update wait group for this thread to complete
-      go func() {
-        var returnCode int
-        for i := 0; i < activeTaskRun.maxRetries + 1; i++ {
-          returnCode, timeElapsed, err := activeTaskRun.Start()
-          if err != nil {
-            log.Errorf(
-              "Could not complete run: %s: %s",
-              activeTaskRun.UUID(),
-              err,
-            )
-          } else if returnCode != 0 {
-            log.Errorf(
-              "Could not complete run: %s: exited with return code %d",
-              activeTaskRun.UUID(),
-              returnCode,
-            )
-          }
-
-          if timeElapsed > 0 {
-            log.Successf("Run %s finished in %s", activeTaskRun.UUID(), timeElapsed)
-            goto activeTaskDone
-          } else {
-            log.Errorf("Run %s finished with errors", activeTaskRun.UUID())
-            if i < activeTaskRun.maxRetries + 1 {
-              log.Infof("Trying run again (%d/%d)", i + 1, activeTaskRun.maxRetries)
-            }
-          }
-        }
+      // The task will never be run, let the explorer know it is finished
+      j.explorer.Report(task)
+    } else {
+      j.waitList.Add(task)
+      added++
+    }
+  }
 
-        if err != nil || returnCode != 0 {
-          // By cancelling all subsequent runs, the task will be removed from 
-          // scheduler.
-          task.(*Task).Cancel()
-        }
+  // Record the permutations which were pruned while exploring
+  err = j.writePrunedFile()
+  if err != nil {
+    return added, len(tasks), err
+  }
 
-activeTaskDone:
-        wg.Done() // We're done here
+  return added, len(tasks), nil
+}
 
-        // Remove utilized cores from this active task's run
-        for _, coreId := range activeTaskRun.CoreIds {
-          tasksInFlight.Unset(coreId)
-        }
-      }()
+// report informs the explorer that the task has finished
+func (j *Job) report(task *Task) {
+  j.exploreLock.Lock()
+  if !task.cancelled {
+    j.measure(task)
+  }
+  if !j.dryRun {
+    status := TaskComplete
+    if task.cancelled {
+      status = TaskFailed
+    }
+    if err := task.writeManifest(status); err != nil {
+      log.Warnf("Could not record task %s: %s", task.UUID(), err)
     }
+  }
+  j.explorer.Report(task)
+  j.exploreLock.Unlock()
+}
 
-iterator:
-    time.Sleep(time.Duration(j.scheduleGrace) * time.Second)
+// taskRecord is the serialized form of a task which was scheduled
+type taskRecord struct {
+  UUID      string            `json:"uuid"`
+  Hash      string            `json:"hash"`
+  Params    map[string]string `json:"params"`
+  Scheduled time.Time         `json:"scheduled"`
+}
 
-    // Remove the task if the queue is empty
-    if task.(*Task).runs.Len() == 0 {
-      j.waitList.Remove(i)
-      i = i - 1
-    }
+// recordTask appends the task to the record of scheduled tasks
+func (j *Job) recordTask(task *Task) error {
+  b, err := json.Marshal(taskRecord{
+    UUID:      task.UUID(),
+    Hash:      task.Hash(),
+    Params:    task.paramsMap(),
+    Scheduled: time.Now(),
+  })
+  if err != nil {
+    return fmt.Errorf("Could not marshal JSON of task: %s", err)
+  }
 
-    // Have we reached the end of the list?  Go back to zero otherwise continue.
-    if j.waitList.Len() == i + 1 {
-      i = 0
-    } else {
-      i = i + 1
-    }
+  return appendLine(path.Join(j.workDir, "results", "tasks.jsonl"), b)
+}
+
+// appendLine appends a line to the file, creating it if necessary
+func appendLine(filePath string, line []byte) error {
+  f, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+  if err != nil {
+    return fmt.Errorf("Could not open %s: %s", filePath, err)
   }
+  defer f.Close()
 
-  wg.Wait() // Wait for all controller threads for the task's run to finish
+  _, err = f.Write(append(line, '\n'))
+  if err != nil {
+    return fmt.Errorf("Could not write %s: %s", filePath, err)
+  }
 
   return nil
 }
 
+// exploring returns whether the explorer has more tasks to propose
+func (j *Job) exploring() bool {
+  j.exploreLock.Lock()
+  defer j.exploreLock.Unlock()
+
+  return !j.explorer.Done()
+}
+
 // Cleanup provides a way to deschedule all currently active tasks
 func (j *Job) Cleanup() {
   // Iterate through active tasks
   for _, atr := range tasksInFlight.All() {
     // Skip cores which do not have a task
-    if atr == nil {
+    if atr == nil || atr.Runner == nil {
       continue
     }
 
@@ -0,0 +1,904 @@
+package job
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "os"
+  "fmt"
+  "path"
+  "bufio"
+  "regexp"
+  "strings"
+  "strconv"
+  "unicode"
+  "path/filepath"
+
+  "github.com/lancs-net/wayfinder/log"
+)
+
+// JobParamsFrom describes an external source the job's parameters are
+// imported from.
+type JobParamsFrom struct {
+  Type      string   `yaml:"type"`
+  Path      string   `yaml:"path"`
+  Srctree   string   `yaml:"srctree"`
+  Include []string   `yaml:"include"`
+  Exclude []string   `yaml:"exclude"`
+}
+
+// ImportParams generates parameters from the source described by from
+func ImportParams(from *JobParamsFrom) ([]JobParam, error) {
+  switch from.Type {
+  case "kconfig":
+    return importKconfig(from)
+  }
+
+  return nil, fmt.Errorf("Unknown parameter source type: \"%s\"", from.Type)
+}
+
+var (
+  dotconfigSet    = regexp.MustCompile(`^CONFIG_(\w+)=(.*)$`)
+  dotconfigNotSet = regexp.MustCompile(`^# CONFIG_(\w+) is not set$`)
+)
+
+// importKconfig generates parameters from a Kconfig tree or from a .config,
+// depending on the contents of the file.
+func importKconfig(from *JobParamsFrom) ([]JobParam, error) {
+  if len(from.Path) == 0 {
+    return nil, fmt.Errorf("Kconfig path cannot be empty")
+  }
+
+  isDotconfig, err := isDotconfig(from.Path)
+  if err != nil {
+    return nil, err
+  }
+
+  if isDotconfig {
+    return parseDotconfig(from)
+  }
+
+  srctree := from.Srctree
+  if len(srctree) == 0 {
+    srctree = path.Dir(from.Path)
+  }
+
+  p := &kconfigParser{
+    srctree: srctree,
+    symbols: make(map[string]*kconfigEntry),
+  }
+
+  err = p.parseFile(from.Path, false)
+  if err != nil {
+    return nil, err
+  }
+
+  return p.params(from)
+}
+
+// isDotconfig returns whether the first statement of the file is an assignment
+// of a configuration option, as found in a .config.
+func isDotconfig(filePath string) (bool, error) {
+  f, err := os.Open(filePath)
+  if err != nil {
+    return false, fmt.Errorf("Could not open Kconfig: %s", err)
+  }
+  defer f.Close()
+
+  scanner := bufio.NewScanner(f)
+  for scanner.Scan() {
+    line := strings.TrimSpace(scanner.Text())
+    if dotconfigSet.MatchString(line) || dotconfigNotSet.MatchString(line) {
+      return true, nil
+    }
+    if len(line) > 0 && !strings.HasPrefix(line, "#") {
+      return false, nil
+    }
+  }
+
+  return false, scanner.Err()
+}
+
+// kconfigSelected returns whether the symbol is included and not excluded
+func kconfigSelected(from *JobParamsFrom, names ...string) bool {
+  included := len(from.Include) == 0
+  for _, name := range names {
+    for _, pattern := range from.Include {
+      if ok, _ := path.Match(pattern, name); ok {
+        included = true
+      }
+    }
+  }
+
+  if !included {
+    return false
+  }
+
+  for _, name := range names {
+    for _, pattern := range from.Exclude {
+      if ok, _ := path.Match(pattern, name); ok {
+        return false
+      }
+    }
+  }
+
+  return true
+}
+
+// parseDotconfig generates parameters from the options set in a .config.
+// Since a .config carries no types, these are inferred from the values.
+func parseDotconfig(from *JobParamsFrom) ([]JobParam, error) {
+  f, err := os.Open(from.Path)
+  if err != nil {
+    return nil, fmt.Errorf("Could not open .config: %s", err)
+  }
+  defer f.Close()
+
+  var params []JobParam
+
+  scanner := bufio.NewScanner(f)
+  for scanner.Scan() {
+    line := strings.TrimSpace(scanner.Text())
+
+    var name, val string
+    if match := dotconfigSet.FindStringSubmatch(line); match != nil {
+      name, val = match[1], match[2]
+    } else if match := dotconfigNotSet.FindStringSubmatch(line); match != nil {
+      name, val = match[1], "n"
+    } else {
+      continue
+    }
+
+    if !kconfigSelected(from, name) {
+      continue
+    }
+
+    param := JobParam{
+      Name: name,
+    }
+
+    switch {
+    case val == "y" || val == "n":
+      param.Type = "string"
+      param.Only = []string{"y", "n"}
+    case val == "m":
+      param.Type = "string"
+      param.Only = []string{"y", "n", "m"}
+    case strings.HasPrefix(val, "0x"):
+      param.Type = "hex"
+      param.Default = val
+    case strings.HasPrefix(val, "\""):
+      str, err := strconv.Unquote(val)
+      if err != nil {
+        return nil, fmt.Errorf("Invalid value for %s: %s", name, val)
+      }
+      param.Type = "string"
+      param.Default = str
+    default:
+      if _, err := strconv.Atoi(val); err != nil {
+        return nil, fmt.Errorf("Invalid value for %s: %s", name, val)
+      }
+      param.Type = "integer"
+      param.Default = val
+    }
+
+    // Empty strings cannot be distinguished from unset parameters
+    if param.Type == "string" && len(param.Only) == 0 && len(param.Default) == 0 {
+      log.Debugf("Skipping empty option: %s", name)
+      continue
+    }
+
+    params = append(params, param)
+  }
+
+  if err := scanner.Err(); err != nil {
+    return nil, fmt.Errorf("Could not read .config: %s", err)
+  }
+
+  return params, nil
+}
+
+// kconfigEntry is a config, choice, menu or if block of a Kconfig tree
+type kconfigEntry struct {
+  kind      string // one of: config, choice, menu, if, comment
+  name      string
+  typ       string
+  prompt    bool
+  def       string
+  min       string
+  max       string
+  depends []string
+  parents []*kconfigEntry
+  members []*kconfigEntry
+  choice   *kconfigEntry
+}
+
+// kconfigParser parses a tree of Kconfig files into its entries
+type kconfigParser struct {
+  srctree  string
+  entries []*kconfigEntry
+  symbols  map[string]*kconfigEntry
+}
+
+// kconfigLine is a logical line of a Kconfig file
+type kconfigLine struct {
+  indent int
+  text   string
+}
+
+// readKconfig reads the logical lines of a Kconfig file, joining those which
+// are continued with a trailing backslash.
+func readKconfig(filePath string) ([]kconfigLine, error) {
+  f, err := os.Open(filePath)
+  if err != nil {
+    return nil, err
+  }
+  defer f.Close()
+
+  var lines []kconfigLine
+  var cont *kconfigLine
+
+  scanner := bufio.NewScanner(f)
+  for scanner.Scan() {
+    text := scanner.Text()
+
+    if cont != nil {
+      cont.text += " " + strings.TrimSpace(text)
+    } else {
+      indent := 0
+      for _, c := range text {
+        if c == '\t' {
+          indent += 8 - indent % 8
+        } else if c == ' ' {
+          indent++
+        } else {
+          break
+        }
+      }
+      lines = append(lines, kconfigLine{indent, strings.TrimSpace(text)})
+      cont = &lines[len(lines)-1]
+    }
+
+    if strings.HasSuffix(cont.text, "\\") {
+      cont.text = strings.TrimSuffix(cont.text, "\\")
+    } else {
+      cont = nil
+    }
+  }
+
+  return lines, scanner.Err()
+}
+
+// stripKconfigComment removes a trailing comment outside of quotes
+func stripKconfigComment(text string) string {
+  var quote rune
+  for i, c := range text {
+    switch {
+    case quote != 0:
+      if c == quote {
+        quote = 0
+      }
+    case c == '"' || c == '\'':
+      quote = c
+    case c == '#':
+      return strings.TrimSpace(text[:i])
+    }
+  }
+
+  return text
+}
+
+// kconfigValue returns the literal value at the start of the text, ignoring
+// any condition which follows, or false when it is not a literal.
+func kconfigValue(text string) (string, bool) {
+  if strings.HasPrefix(text, "\"") || strings.HasPrefix(text, "'") {
+    end := strings.IndexRune(text[1:], rune(text[0]))
+    if end < 0 {
+      return "", false
+    }
+    return text[1:end+1], true
+  }
+
+  fields := strings.Fields(text)
+  if len(fields) == 0 {
+    return "", false
+  }
+
+  val := fields[0]
+  if val == "y" || val == "n" || val == "m" {
+    return val, true
+  }
+  if _, err := strconv.ParseInt(val, 0, 64); err == nil {
+    return val, true
+  }
+
+  return "", false
+}
+
+// expandKconfig substitutes environment variables in a Kconfig path, written
+// either as $(VAR) or $VAR.
+func expandKconfig(text string) string {
+  text = regexp.MustCompile(`\$\((\w+)\)`).ReplaceAllString(text, "$${$1}")
+  return os.ExpandEnv(text)
+}
+
+// parseFile parses a Kconfig file and the files it sources
+func (p *kconfigParser) parseFile(filePath string, optional bool) error {
+  lines, err := readKconfig(filePath)
+  if err != nil {
+    if optional && os.IsNotExist(err) {
+      return nil
+    }
+    return fmt.Errorf("Could not read Kconfig: %s", err)
+  }
+
+  log.Debugf("Parsing Kconfig %s...", filePath)
+
+  var stack []*kconfigEntry
+  var current *kconfigEntry
+  help := -1
+
+  // push opens a new entry which contains the subsequent entries
+  push := func(entry *kconfigEntry) {
+    entry.parents = append([]*kconfigEntry{}, stack...)
+    stack = append(stack, entry)
+    current = entry
+  }
+
+  // pop closes the innermost entry of the given kind
+  pop := func(kind string, n int) error {
+    for len(stack) > 0 {
+      top := stack[len(stack)-1]
+      stack = stack[:len(stack)-1]
+      if top.kind == kind {
+        current = nil
+        return nil
+      }
+    }
+    return fmt.Errorf("%s:%d: end%s without %s", filePath, n, kind, kind)
+  }
+
+  for n, line := range lines {
+    // Skip the indented help text of the previous entry
+    if help >= 0 {
+      if len(line.text) == 0 || line.indent > help {
+        continue
+      }
+      help = -1
+    }
+
+    text := stripKconfigComment(line.text)
+    if len(text) == 0 {
+      continue
+    }
+
+    keyword, rest := text, ""
+    if i := strings.IndexFunc(text, unicode.IsSpace); i >= 0 {
+      keyword, rest = text[:i], strings.TrimSpace(text[i:])
+    }
+
+    switch keyword {
+    case "config", "menuconfig":
+      entry := &kconfigEntry{
+        kind:    "config",
+        name:    rest,
+        parents: append([]*kconfigEntry{}, stack...),
+      }
+      current = entry
+
+      for i := len(stack) - 1; i >= 0; i-- {
+        if stack[i].kind == "choice" {
+          entry.choice = stack[i]
+          break
+        }
+      }
+
+      // Symbols may be defined in several places, only the first is used
+      if _, ok := p.symbols[rest]; ok {
+        log.Debugf("Ignoring redefinition of %s", rest)
+        continue
+      }
+      p.symbols[rest] = entry
+
+      if entry.choice != nil {
+        entry.choice.members = append(entry.choice.members, entry)
+      } else {
+        p.entries = append(p.entries, entry)
+      }
+
+    case "choice":
+      entry := &kconfigEntry{
+        kind: "choice",
+        name: rest,
+      }
+      push(entry)
+      p.entries = append(p.entries, entry)
+
+    case "endchoice", "endmenu", "endif":
+      err := pop(strings.TrimPrefix(keyword, "end"), n + 1)
+      if err != nil {
+        return err
+      }
+
+    case "menu":
+      push(&kconfigEntry{kind: "menu"})
+
+    case "if":
+      push(&kconfigEntry{kind: "if", depends: []string{rest}})
+      current = nil
+
+    case "comment":
+      current = &kconfigEntry{kind: "comment"}
+
+    case "mainmenu":
+      current = nil
+
+    case "source", "rsource", "osource", "orsource":
+      src, ok := kconfigValue(rest)
+      if !ok {
+        src = rest
+      }
+      src = expandKconfig(src)
+
+      if !path.IsAbs(src) {
+        if strings.HasSuffix(keyword, "rsource") {
+          src = path.Join(path.Dir(filePath), src)
+        } else {
+          src = path.Join(p.srctree, src)
+        }
+      }
+
+      optional := strings.HasPrefix(keyword, "o")
+      matches, err := filepath.Glob(src)
+      if err != nil {
+        return fmt.Errorf("%s:%d: invalid source: %s", filePath, n + 1, src)
+      }
+      if len(matches) == 0 && !strings.ContainsAny(src, "*?[") {
+        matches = []string{src}
+      }
+
+      for _, match := range matches {
+        err := p.parseFile(match, optional)
+        if err != nil {
+          return err
+        }
+      }
+
+    case "help", "---help---":
+      help = line.indent
+
+    default:
+      if current == nil {
+        continue
+      }
+
+      switch keyword {
+      case "bool", "boolean", "tristate", "int", "hex", "string":
+        current.typ = strings.TrimSuffix(keyword, "ean")
+        if strings.HasPrefix(rest, "\"") || strings.HasPrefix(rest, "'") {
+          current.prompt = true
+        }
+
+      case "def_bool", "def_tristate":
+        current.typ = strings.TrimPrefix(keyword, "def_")
+        if val, ok := kconfigValue(rest); ok && len(current.def) == 0 {
+          current.def = val
+        }
+
+      case "prompt":
+        current.prompt = true
+
+      case "default":
+        if val, ok := kconfigValue(rest); ok && len(current.def) == 0 {
+          current.def = val
+        }
+
+      case "range":
+        fields := strings.Fields(rest)
+        if len(fields) >= 2 && len(current.min) == 0 {
+          current.min, current.max = fields[0], fields[1]
+        }
+
+      case "depends":
+        if strings.HasPrefix(rest, "on ") {
+          current.depends = append(current.depends, strings.TrimSpace(rest[3:]))
+        }
+      }
+    }
+  }
+
+  if len(stack) > 0 {
+    return fmt.Errorf("%s: %s is not closed", filePath, stack[len(stack)-1].kind)
+  }
+
+  return nil
+}
+
+// kconfigRef describes how a symbol is referred to in the condition of a
+// parameter which depends on it.
+type kconfigRef struct {
+  param  string
+  typ    string
+  member string // the symbol when it is a member of a choice
+}
+
+// params generates the parameters of the symbols which can be set by users,
+// in the order they are defined.
+func (p *kconfigParser) params(from *JobParamsFrom) ([]JobParam, error) {
+  var params []JobParam
+  refs := make(map[string]kconfigRef)
+
+  for _, entry := range p.entries {
+    param := JobParam{
+      Name: entry.name,
+    }
+
+    switch entry.kind {
+    case "config":
+      if !entry.prompt || !kconfigSelected(from, entry.name) {
+        continue
+      }
+
+      switch entry.typ {
+      case "bool":
+        param.Type = "string"
+        param.Only = []string{"y", "n"}
+      case "tristate":
+        param.Type = "string"
+        param.Only = []string{"y", "n", "m"}
+      case "int", "hex":
+        param.Type = entry.typ
+        if param.Type == "int" {
+          param.Type = "integer"
+        }
+        _, minOk := kconfigValue(entry.min)
+        _, maxOk := kconfigValue(entry.max)
+        if minOk && maxOk {
+          param.Min, param.Max = entry.min, entry.max
+        } else {
+          param.Default = entry.def
+        }
+      case "string":
+        param.Type = "string"
+        param.Default = entry.def
+      default:
+        continue
+      }
+
+      if len(param.Only) == 0 && len(param.Min) == 0 && len(param.Default) == 0 {
+        log.Debugf("Skipping option without a range or default: %s", entry.name)
+        continue
+      }
+
+    case "choice":
+      var names []string
+      for _, member := range entry.members {
+        if member.prompt {
+          names = append(names, member.name)
+        }
+      }
+      if len(names) == 0 {
+        continue
+      }
+
+      // Anonymous choices are named after their first option
+      if len(param.Name) == 0 {
+        param.Name = names[0] + "_CHOICE"
+      }
+
+      if !kconfigSelected(from, append([]string{param.Name}, names...)...) {
+        continue
+      }
+
+      param.Type = "string"
+      param.Only = names
+
+    default:
+      continue
+    }
+
+    // Convert the dependencies into the parameter's condition, keeping only
+    // those which refer to parameters before it
+    var conds []string
+    seen := make(map[string]bool)
+    for _, dep := range entry.dependencies() {
+      for _, conj := range splitKconfigAnd(dep) {
+        cond, err := convertKconfigExpr(conj, refs)
+        if err != nil {
+          log.Debugf("Ignoring dependency of %s on %s: %s", param.Name, conj, err)
+          continue
+        }
+        if !seen[cond] {
+          seen[cond] = true
+          conds = append(conds, cond)
+        }
+      }
+    }
+
+    if len(conds) > 0 {
+      if len(conds) == 1 {
+        param.When = conds[0]
+      } else {
+        param.When = "(" + strings.Join(conds, ") && (") + ")"
+      }
+
+      // A bool or tristate whose dependencies are not met is disabled
+      if entry.typ == "bool" || entry.typ == "tristate" {
+        param.Default = "n"
+      }
+    }
+
+    if entry.kind == "choice" {
+      for _, member := range entry.members {
+        refs[member.name] = kconfigRef{param: param.Name, member: member.name}
+      }
+    } else {
+      refs[param.Name] = kconfigRef{param: param.Name, typ: entry.typ}
+    }
+
+    params = append(params, param)
+  }
+
+  return params, nil
+}
+
+// dependencies returns the dependencies of the entry and its parents
+func (e *kconfigEntry) dependencies() []string {
+  var deps []string
+  for _, parent := range e.parents {
+    deps = append(deps, parent.depends...)
+  }
+
+  return append(deps, e.depends...)
+}
+
+// splitKconfigAnd splits an expression into the operands of its top-level &&
+func splitKconfigAnd(expr string) []string {
+  var parts []string
+
+  depth, start := 0, 0
+  for i := 0; i < len(expr); i++ {
+    switch {
+    case expr[i] == '(':
+      depth++
+    case expr[i] == ')':
+      depth--
+    case depth == 0 && strings.HasPrefix(expr[i:], "&&"):
+      parts = append(parts, strings.TrimSpace(expr[start:i]))
+      start = i + 2
+      i++
+    }
+  }
+
+  return append(parts, strings.TrimSpace(expr[start:]))
+}
+
+// kconfigConverter converts a Kconfig expression into a parameter condition
+type kconfigConverter struct {
+  tokens []string
+  pos    int
+  refs   map[string]kconfigRef
+}
+
+var kconfigToken = regexp.MustCompile(`\s*("[^"]*"|'[^']*'|&&|\|\||!=|<=|>=|[=<>!()]|[\w.-]+)`)
+
+// convertKconfigExpr converts a Kconfig expression into a parameter condition,
+// failing when it refers to symbols which are not parameters.  Environment
+// variables are substituted first, as they are in the paths of sources.
+func convertKconfigExpr(expr string, refs map[string]kconfigRef) (string, error) {
+  c := &kconfigConverter{refs: refs}
+
+  rest := strings.TrimSpace(expandKconfig(expr))
+  for len(rest) > 0 {
+    loc := kconfigToken.FindStringSubmatchIndex(rest)
+    if loc == nil || loc[0] != 0 {
+      return "", fmt.Errorf("unexpected %s", rest)
+    }
+    c.tokens = append(c.tokens, rest[loc[2]:loc[3]])
+    rest = strings.TrimSpace(rest[loc[1]:])
+  }
+
+  cond, err := c.parseOr()
+  if err != nil {
+    return "", err
+  }
+  if c.pos < len(c.tokens) {
+    return "", fmt.Errorf("unexpected %s", c.tokens[c.pos])
+  }
+
+  return cond, nil
+}
+
+// peek returns the next token or an empty string at the end
+func (c *kconfigConverter) peek() string {
+  if c.pos < len(c.tokens) {
+    return c.tokens[c.pos]
+  }
+  return ""
+}
+
+func (c *kconfigConverter) parseOr() (string, error) {
+  left, err := c.parseAnd()
+  if err != nil {
+    return "", err
+  }
+  for c.peek() == "||" {
+    c.pos++
+    right, err := c.parseAnd()
+    if err != nil {
+      return "", err
+    }
+    left = left + " || " + right
+  }
+  return left, nil
+}
+
+func (c *kconfigConverter) parseAnd() (string, error) {
+  left, err := c.parseNot()
+  if err != nil {
+    return "", err
+  }
+  for c.peek() == "&&" {
+    c.pos++
+    right, err := c.parseNot()
+    if err != nil {
+      return "", err
+    }
+    left = left + " && " + right
+  }
+  return left, nil
+}
+
+func (c *kconfigConverter) parseNot() (string, error) {
+  if c.peek() == "!" {
+    c.pos++
+    operand, err := c.parseNot()
+    if err != nil {
+      return "", err
+    }
+    return "!(" + operand + ")", nil
+  }
+  return c.parseCompare()
+}
+
+func (c *kconfigConverter) parseCompare() (string, error) {
+  tok := c.peek()
+  if len(tok) == 0 {
+    return "", fmt.Errorf("unexpected end of expression")
+  }
+  c.pos++
+
+  if tok == "(" {
+    inner, err := c.parseOr()
+    if err != nil {
+      return "", err
+    }
+    if c.peek() != ")" {
+      return "", fmt.Errorf("missing )")
+    }
+    c.pos++
+    return "(" + inner + ")", nil
+  }
+
+  op := c.peek()
+  switch op {
+  case "=", "!=", "<", "<=", ">", ">=":
+    c.pos++
+    other := c.peek()
+    if len(other) == 0 {
+      return "", fmt.Errorf("unexpected end of expression")
+    }
+    c.pos++
+
+    if op == "=" {
+      op = "=="
+    }
+
+    // Members of a choice are compared by whether the choice selects them
+    if ref, ok := c.refs[tok]; ok && len(ref.member) > 0 {
+      return c.member(ref, op, other)
+    }
+    if ref, ok := c.refs[other]; ok && len(ref.member) > 0 {
+      return c.member(ref, op, tok)
+    }
+
+    left, err := c.value(tok)
+    if err != nil {
+      return "", err
+    }
+    right, err := c.value(other)
+    if err != nil {
+      return "", err
+    }
+    return left + " " + op + " " + right, nil
+  }
+
+  // A symbol on its own is true when it is enabled
+  switch tok {
+  case "y", "m":
+    return "true", nil
+  case "n":
+    return "false", nil
+  }
+
+  ref, ok := c.refs[tok]
+  if !ok {
+    return "", fmt.Errorf("unknown symbol %s", tok)
+  }
+
+  switch {
+  case len(ref.member) > 0:
+    return fmt.Sprintf("%s == \"%s\"", ref.param, ref.member), nil
+  case ref.typ == "bool":
+    return fmt.Sprintf("%s == \"y\"", ref.param), nil
+  case ref.typ == "tristate":
+    return fmt.Sprintf("%s != \"n\"", ref.param), nil
+  }
+
+  return "", fmt.Errorf("%s is not a bool or tristate", tok)
+}
+
+// member converts the comparison of a member of a choice with y or n
+func (c *kconfigConverter) member(ref kconfigRef, op string, val string) (string, error) {
+  val = strings.Trim(val, "\"'")
+  if (op != "==" && op != "!=") || (val != "y" && val != "n") {
+    return "", fmt.Errorf("cannot compare choice %s with %s", ref.member, val)
+  }
+
+  if (op == "==") != (val == "y") {
+    op = "!="
+  } else {
+    op = "=="
+  }
+
+  return fmt.Sprintf("%s %s \"%s\"", ref.param, op, ref.member), nil
+}
+
+// value converts an operand of a comparison
+func (c *kconfigConverter) value(tok string) (string, error) {
+  if ref, ok := c.refs[tok]; ok {
+    return ref.param, nil
+  }
+
+  if strings.HasPrefix(tok, "\"") || strings.HasPrefix(tok, "'") {
+    return "\"" + strings.Trim(tok, "\"'") + "\"", nil
+  }
+
+  // Numbers are compared numerically, whereas y, n and m are strings
+  if _, err := strconv.ParseInt(tok, 0, 64); err == nil {
+    return tok, nil
+  }
+
+  if _, ok := kconfigValue(tok); ok {
+    return "\"" + tok + "\"", nil
+  }
+
+  return "", fmt.Errorf("unknown symbol %s", tok)
+}
@@ -0,0 +1,191 @@
+package job
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "os"
+  "path"
+  "reflect"
+  "testing"
+  "io/ioutil"
+)
+
+// kconfigTree is a small Kconfig tree which sources a file relative to itself
+var kconfigTree = map[string]string{
+  "Kconfig": `mainmenu "Test"
+
+config NET
+	bool "Networking"
+	default y
+
+if NET
+config NET_BUFS
+	int "Buffers"
+	range 4 64
+	default 16
+	depends on UNKNOWN_SYMBOL
+endif
+
+choice
+	prompt "Allocator"
+	default ALLOC_BUDDY
+
+config ALLOC_BUDDY
+	bool "Buddy"
+
+config ALLOC_REGION
+	bool "Region"
+	depends on NET
+endchoice
+
+rsource "lib/Kconfig"
+`,
+  "lib/Kconfig": `config BASE_ADDR
+	hex "Base address"
+	range 0x1000 0xffff
+	depends on ALLOC_REGION=y && NET
+
+config DEBUG
+	bool "Debug"
+	depends on NET_BUFS>=16 # comment
+	help
+	  Enables debugging.
+	  depends on nothing
+
+config HIDDEN
+	bool
+	default y
+`,
+}
+
+// TestKconfigParams checks the parameters generated from a Kconfig tree,
+// including the conditions converted from its dependencies
+func TestKconfigParams(t *testing.T) {
+  root, err := ioutil.TempDir("", "wayfinder-kconfig")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(root)
+
+  for name, dat := range kconfigTree {
+    filePath := path.Join(root, name)
+    if err := os.MkdirAll(path.Dir(filePath), os.ModePerm); err != nil {
+      t.Fatal(err)
+    }
+    if err := ioutil.WriteFile(filePath, []byte(dat), 0644); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  params, err := ImportParams(&JobParamsFrom{
+    Type: "kconfig",
+    Path: path.Join(root, "Kconfig"),
+  })
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  expect := []JobParam{
+    {Name: "NET", Type: "string", Only: []string{"y", "n"}},
+    {Name: "NET_BUFS", Type: "integer", Min: "4", Max: "64", When: `NET == "y"`},
+    {Name: "ALLOC_BUDDY_CHOICE", Type: "string", Only: []string{"ALLOC_BUDDY", "ALLOC_REGION"}},
+    {
+      Name: "BASE_ADDR",
+      Type: "hex",
+      Min:  "0x1000",
+      Max:  "0xffff",
+      When: `(ALLOC_BUDDY_CHOICE == "ALLOC_REGION") && (NET == "y")`,
+    },
+    {
+      Name:    "DEBUG",
+      Type:    "string",
+      Only:    []string{"y", "n"},
+      Default: "n",
+      When:    "NET_BUFS >= 16",
+    },
+  }
+
+  if len(params) != len(expect) {
+    t.Fatalf("Got %d parameters, expected %d: %+v", len(params), len(expect), params)
+  }
+
+  for i := range expect {
+    if !reflect.DeepEqual(params[i], expect[i]) {
+      t.Errorf("Got parameter %+v, expected %+v", params[i], expect[i])
+    }
+  }
+}
+
+// TestConvertKconfigExpr checks the conversion of Kconfig expressions into
+// conditions of parameters
+func TestConvertKconfigExpr(t *testing.T) {
+  os.Setenv("WAYFINDER_TEST_SYMBOL", "NET")
+  defer os.Unsetenv("WAYFINDER_TEST_SYMBOL")
+
+  refs := map[string]kconfigRef{
+    "NET":    {param: "NET", typ: "bool"},
+    "MOD":    {param: "MOD", typ: "tristate"},
+    "BUFS":   {param: "BUFS", typ: "int"},
+    "ADDR":   {param: "ADDR", typ: "hex"},
+    "BUDDY":  {param: "ALLOC", member: "BUDDY"},
+  }
+
+  tests := []struct {
+    expr   string
+    expect string
+    ok     bool
+  }{
+    {"NET", `NET == "y"`, true},
+    {"!NET", `!(NET == "y")`, true},
+    {"MOD", `MOD != "n"`, true},
+    {"NET=n", `NET == "n"`, true},
+    {"NET != y", `NET != "y"`, true},
+    {"BUFS>=16", "BUFS >= 16", true},
+    {"ADDR < 0x2000", "ADDR < 0x2000", true},
+    {"BUDDY=n || (NET && MOD)", `ALLOC != "BUDDY" || (NET == "y" && MOD != "n")`, true},
+    {"y", "true", true},
+    {"$(WAYFINDER_TEST_SYMBOL)", `NET == "y"`, true},
+    {"$(WAYFINDER_TEST_UNSET)", "", false},
+    {"$(cc-option,-fno-pie)", "", false},
+    {"UNKNOWN", "", false},
+    {"NET && UNKNOWN", "", false},
+    {"BUFS", "", false},
+    {"BUDDY < y", "", false},
+    {"(NET", "", false},
+  }
+
+  for _, test := range tests {
+    cond, err := convertKconfigExpr(test.expr, refs)
+    if (err == nil) != test.ok || cond != test.expect {
+      t.Errorf("%s: got %q (%v), expected %q", test.expr, cond, err, test.expect)
+    }
+  }
+}
@@ -32,6 +32,7 @@ package job
 
 import (
   "fmt"
+  "sort"
   "sync"
 
   "github.com/lancs-net/wayfinder/log"
@@ -41,13 +42,18 @@ import (
 // running on the core number defined as the index.
 type CoreMap struct {
   sync.RWMutex
-  cores map[int]*ActiveTaskRun
+  cores           map[int]*ActiveTaskRun
+  topology       *Topology
+  reserveSiblings bool
 }
 
-// CoreMap creates a fixed-length map of cores with their ID as index.
-func NewCoreMap(cores []int) *CoreMap {
+// CoreMap creates a fixed-length map of cores with their ID as index.  Cores
+// are allocated according to the topology, if it is known.
+func NewCoreMap(cores []int, topology *Topology, reserveSiblings bool) *CoreMap {
   coreMap := &CoreMap{
-    cores: make(map[int]*ActiveTaskRun, len(cores)),
+    cores:           make(map[int]*ActiveTaskRun, len(cores)),
+    topology:        topology,
+    reserveSiblings: reserveSiblings,
   }
 
   // Add the core ID as index to the map
@@ -68,9 +74,26 @@ func (cm *CoreMap) FreeCores() []int {
     }
   }
   cm.RUnlock()
+  sort.Ints(free)
   return free
 }
 
+// Allocate selects n of the free cores for a run.  It returns the cores, their
+// siblings which are to be kept idle and the NUMA nodes of the cores, or nil if
+// not enough cores are free.
+func (cm *CoreMap) Allocate(n int) ([]int, []int, []int) {
+  free := cm.FreeCores()
+
+  if cm.topology == nil {
+    if len(free) < n {
+      return nil, nil, nil
+    }
+    return free[len(free)-n:], nil, nil
+  }
+
+  return cm.topology.allocate(free, n, cm.reserveSiblings)
+}
+
 // Set updates the core ID with the task which is actively using it
 func (cm *CoreMap) Set(coreId int, atr *ActiveTaskRun) error {
   cm.Lock()
@@ -0,0 +1,486 @@
+package job
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "io"
+  "os"
+  "fmt"
+  "path"
+  "time"
+  "regexp"
+  "strings"
+  "io/ioutil"
+  "crypto/sha256"
+  "encoding/json"
+  "path/filepath"
+
+  "github.com/novln/docker-parser"
+
+  "github.com/lancs-net/wayfinder/log"
+  "github.com/lancs-net/wayfinder/run"
+)
+
+const (
+  TaskRunning  = "running"
+  TaskComplete = "complete"
+  TaskFailed   = "failed"
+)
+
+const (
+  OutcomeSucceeded = "succeeded"
+  OutcomeFailed    = "failed"
+  OutcomeTimeout   = "timeout"
+)
+
+// digestUnresolved is the digest of images which were not looked up
+const digestUnresolved = "unresolved"
+
+// errTaskComplete is returned when the results of a task can be reused
+var errTaskComplete = fmt.Errorf("Task is already complete")
+
+// TaskManifest describes everything the results of a task were produced from
+type TaskManifest struct {
+  UUID      string            `json:"uuid"`
+  Hash      string            `json:"hash"`
+  Params    map[string]string `json:"params"`
+  Runs    []ManifestRun       `json:"runs"`
+  Inputs  []ManifestInput     `json:"inputs"`
+  Durations map[string]float64 `json:"durations,omitempty"` // seconds per run
+  Outcomes  map[string]string  `json:"outcomes,omitempty"` // of each run
+  Until     map[string]*ManifestUntil `json:"until,omitempty"` // by run
+  Status    string            `json:"status,omitempty"`
+  Scheduled *time.Time        `json:"scheduled,omitempty"`
+  Finished  *time.Time        `json:"finished,omitempty"`
+  params    []string          // active parameters in the order of the task
+}
+
+// ManifestRun is a run as it is evaluated for the task
+type ManifestRun struct {
+  Name           string `json:"name"`
+  Image          string `json:"image"`
+  Digest         string `json:"digest"`
+  Cores          int    `json:"cores"`
+  Devices      []string `json:"devices,omitempty"`
+  Cmd            string `json:"cmd,omitempty"`
+  Path           string `json:"path,omitempty"`
+  Capabilities []string `json:"capabilities,omitempty"`
+  DependsOn    []string `json:"depends_on,omitempty"`
+  Repeat         int    `json:"repeat,omitempty"`
+  Memory         string `json:"memory,omitempty"`
+  MemorySwap     string `json:"memory_swap,omitempty"`
+  Hugepages      map[string]string `json:"hugepages,omitempty"`
+  PidsLimit      int64  `json:"pids_limit,omitempty"`
+  Until         *run.RunUntil `json:"until,omitempty"`
+}
+
+// ManifestUntil records the metric of each repetition of a run which is
+// repeated until its confidence interval is narrow enough, and why it stopped
+type ManifestUntil struct {
+  Metric      string    `json:"metric"`
+  Values    []float64   `json:"values"`
+  Mean        float64   `json:"mean"`
+  Stddev      float64   `json:"stddev"`
+  CI          float64   `json:"ci"`
+  HalfWidth   float64   `json:"half_width"`
+  RelWidth    float64   `json:"rel_width"`
+  Repetitions int       `json:"repetitions"`
+  Decision    string    `json:"decision,omitempty"`
+}
+
+// ManifestInput is an input as it is evaluated for the task
+type ManifestInput struct {
+  Destination string   `json:"destination"`
+  Hash        string   `json:"hash"`
+  Options   []string   `json:"options,omitempty"`
+}
+
+// newManifest evaluates the runs and inputs of the task and computes the hash
+// of its contents.
+func (j *Job) newManifest(task *Task) (*TaskManifest, error) {
+  m := &TaskManifest{
+    UUID:   task.UUID(),
+    Params: make(map[string]string),
+  }
+
+  var params []string
+  for _, param := range task.Params {
+    if param.Inactive {
+      continue
+    }
+    m.Params[param.Name] = param.Value
+    params = append(params, fmt.Sprintf("%s=%s", param.Name, param.Value))
+  }
+
+  for _, r := range j.Runs {
+    r, err := task.renderRun(r)
+    if err != nil {
+      return nil, err
+    }
+
+    digest, err := j.imageDigest(r.Image)
+    if err != nil {
+      return nil, err
+    }
+
+    m.Runs = append(m.Runs, ManifestRun{
+      Name:         r.Name,
+      Image:        r.Image,
+      Digest:       digest,
+      Cores:        r.Cores,
+      Devices:      r.Devices,
+      Cmd:          r.Cmd,
+      Path:         r.Path,
+      Capabilities: r.Capabilities,
+      DependsOn:    r.DependsOn,
+      Repeat:       r.Repeat,
+      Memory:       r.Memory,
+      MemorySwap:   r.MemorySwap,
+      Hugepages:    r.Hugepages,
+      PidsLimit:    r.PidsLimit,
+      Until:        r.Until,
+    })
+  }
+
+  for _, input := range *task.Inputs {
+    input, content, err := task.renderInput(input)
+    if err != nil {
+      return nil, err
+    }
+
+    var hash string
+    if content != nil {
+      hash = fmt.Sprintf("%x", sha256.Sum256([]byte(*content)))
+    } else {
+      hash, err = j.inputHash(input.Source)
+      if err != nil {
+        return nil, err
+      }
+    }
+
+    m.Inputs = append(m.Inputs, ManifestInput{
+      Destination: input.Destination,
+      Hash:        hash,
+      Options:     input.Options,
+    })
+  }
+
+  var err error
+  m.params = params
+  m.Hash, err = m.hash()
+  if err != nil {
+    return nil, err
+  }
+
+  return m, nil
+}
+
+// hash returns the hash of the definition of the task but not its progress
+func (m *TaskManifest) hash() (string, error) {
+  b, err := json.Marshal(struct {
+    Params []string
+    Runs   []ManifestRun
+    Inputs []ManifestInput
+  }{m.params, m.Runs, m.Inputs})
+  if err != nil {
+    return "", fmt.Errorf("Could not marshal JSON of task: %s", err)
+  }
+
+  return fmt.Sprintf("%x", sha256.Sum256(b)), nil
+}
+
+// maxImageCombinations is the most combinations of parameter values an image
+// is rendered for to resolve its digests up front
+const maxImageCombinations = 256
+
+// resolveDigests resolves the digest of each distinct image of the job's runs
+// once, before any task is explored.  Images which are templates are rendered
+// for every combination of the values of the parameters they refer to.
+func (j *Job) resolveDigests() error {
+  for _, r := range j.Runs {
+    images, err := j.runImages(r)
+    if err != nil {
+      return err
+    }
+
+    for _, image := range images {
+      _, err := j.imageDigest(image)
+      if err != nil {
+        return err
+      }
+    }
+  }
+
+  return nil
+}
+
+// runImages returns the images which the run's image can be rendered to, or
+// none if there are too many combinations of parameters to consider
+func (j *Job) runImages(r run.Run) ([]string, error) {
+  if !strings.Contains(r.Image, "{{") {
+    return []string{r.Image}, nil
+  }
+
+  combinations := [][]TaskParam{nil}
+  for i, param := range j.Params {
+    re := regexp.MustCompile(`\.` + regexp.QuoteMeta(param.Name) + `\b`)
+    if !re.MatchString(r.Image) {
+      continue
+    }
+
+    values, err := paramPermutations(&j.Params[i])
+    if err != nil {
+      return nil, err
+    }
+
+    var next [][]TaskParam
+    for _, params := range combinations {
+      for _, value := range values {
+        next = append(next, append(append([]TaskParam{}, params...), value))
+      }
+    }
+
+    // The digests are instead resolved when the tasks are explored
+    if len(next) > maxImageCombinations {
+      return nil, nil
+    }
+    combinations = next
+  }
+
+  seen := make(map[string]bool)
+  var images []string
+  for _, params := range combinations {
+    task := &Task{Params: params}
+    image, err := task.render("image", r.Image)
+    if err != nil {
+      return nil, err
+    }
+
+    if !seen[image] {
+      seen[image] = true
+      images = append(images, image)
+    }
+  }
+
+  return images, nil
+}
+
+// imageDigest returns the digest of the image's configuration, looking it up
+// in the image's registry the first time.  In a dry run, the digest is not
+// looked up and is unresolved.
+func (j *Job) imageDigest(image string) (string, error) {
+  if j.digests == nil {
+    j.digests = make(map[string]string)
+  }
+
+  if digest, ok := j.digests[image]; ok {
+    return digest, nil
+  }
+
+  if j.dryRun {
+    j.digests[image] = digestUnresolved
+    return digestUnresolved, nil
+  }
+
+  ref, err := dockerparser.Parse(image)
+  if err != nil {
+    return "", fmt.Errorf("Could not parse image: %s", err)
+  }
+
+  log.Debugf("Resolving digest of %s...", ref.Remote())
+
+  digest, err := run.ImageDigest(ref.Remote())
+  if err != nil {
+    return "", fmt.Errorf("Could not determine digest of %s: %s", image, err)
+  }
+
+  j.digests[image] = digest
+
+  return digest, nil
+}
+
+// assumeDigests takes the digests which are unresolved from the runs of the
+// previous manifest with the same image, assuming the images have not changed,
+// and returns whether any were taken
+func (m *TaskManifest) assumeDigests(previous *TaskManifest) bool {
+  assumed := false
+  for i, r := range m.Runs {
+    if r.Digest != digestUnresolved {
+      continue
+    }
+
+    for _, p := range previous.Runs {
+      if p.Name == r.Name && p.Image == r.Image {
+        m.Runs[i].Digest = p.Digest
+        assumed = true
+      }
+    }
+  }
+
+  return assumed
+}
+
+// inputHash returns the hash of the contents of the file or directory
+func (j *Job) inputHash(source string) (string, error) {
+  if j.inputHashes == nil {
+    j.inputHashes = make(map[string]string)
+  }
+
+  if hash, ok := j.inputHashes[source]; ok {
+    return hash, nil
+  }
+
+  hash := sha256.New()
+  err := filepath.Walk(source, func(p string, info os.FileInfo, err error) error {
+    if err != nil {
+      return err
+    }
+
+    rel, err := filepath.Rel(source, p)
+    if err != nil {
+      return err
+    }
+
+    // Directories only contribute their structure
+    if info.IsDir() {
+      fmt.Fprintf(hash, "%s/\n", rel)
+      return nil
+    }
+
+    if info.Mode() & os.ModeSymlink != 0 {
+      target, err := os.Readlink(p)
+      if err != nil {
+        return err
+      }
+      fmt.Fprintf(hash, "%s -> %s\n", rel, target)
+      return nil
+    }
+
+    f, err := os.Open(p)
+    if err != nil {
+      return err
+    }
+    defer f.Close()
+
+    fmt.Fprintf(hash, "%s %o\n", rel, info.Mode().Perm())
+    _, err = io.Copy(hash, f)
+    return err
+  })
+  if err != nil {
+    return "", fmt.Errorf("Could not hash input %s: %s", source, err)
+  }
+
+  j.inputHashes[source] = fmt.Sprintf("%x", hash.Sum(nil))
+
+  return j.inputHashes[source], nil
+}
+
+// manifestKey returns the name of the repetition of the run in the manifest,
+// where repetitions are recorded as <run>/<rep>
+func (t *Task) manifestKey(ri runInstance) string {
+  name := t.runs[ri.index].Name
+  if repeats(t.runs[ri.index]) > 1 {
+    name = fmt.Sprintf("%s/%d", name, ri.rep)
+  }
+
+  return name
+}
+
+// recordDuration remembers how long the repetition of the run of the task took
+func (t *Task) recordDuration(ri runInstance, d time.Duration) {
+  if t.manifest == nil {
+    return
+  }
+
+  if t.manifest.Durations == nil {
+    t.manifest.Durations = make(map[string]float64)
+  }
+
+  t.manifest.Durations[t.manifestKey(ri)] = d.Seconds()
+}
+
+// recordOutcome remembers how the repetition of the run of the task finished
+func (t *Task) recordOutcome(ri runInstance, outcome string) {
+  if t.manifest == nil {
+    return
+  }
+
+  if t.manifest.Outcomes == nil {
+    t.manifest.Outcomes = make(map[string]string)
+  }
+
+  t.manifest.Outcomes[t.manifestKey(ri)] = outcome
+}
+
+// readManifest returns the manifest in the results directory, if there is one
+func readManifest(resultsDir string) (*TaskManifest, error) {
+  dat, err := ioutil.ReadFile(path.Join(resultsDir, "manifest.json"))
+  if os.IsNotExist(err) {
+    return nil, nil
+  } else if err != nil {
+    return nil, fmt.Errorf("Could not read manifest: %s", err)
+  }
+
+  var m TaskManifest
+  err = json.Unmarshal(dat, &m)
+  if err != nil {
+    return nil, fmt.Errorf("Could not parse manifest: %s", err)
+  }
+
+  return &m, nil
+}
+
+// writeManifest saves the task's manifest with its current status
+func (t *Task) writeManifest(status string) error {
+  if t.manifest == nil {
+    return nil
+  }
+
+  now := time.Now()
+  t.manifest.Status = status
+  if status == TaskRunning {
+    t.manifest.Scheduled = &now
+  } else {
+    t.manifest.Finished = &now
+  }
+
+  b, err := json.MarshalIndent(t.manifest, "", "  ")
+  if err != nil {
+    return fmt.Errorf("Could not marshal JSON of manifest: %s", err)
+  }
+
+  err = ioutil.WriteFile(path.Join(t.resultsDir, "manifest.json"), b, 0644)
+  if err != nil {
+    return fmt.Errorf("Could not write manifest: %s", err)
+  }
+
+  return nil
+}
@@ -0,0 +1,352 @@
+package job
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "fmt"
+  "path"
+  "regexp"
+  "strconv"
+  "strings"
+  "io/ioutil"
+  "encoding/json"
+
+  "github.com/tidwall/gjson"
+
+  "github.com/lancs-net/wayfinder/log"
+)
+
+// JobObjective describes a metric of a task, where it is measured from and
+// whether it should be minimized or maximized.
+type JobObjective struct {
+  Metric    string `yaml:"metric"`
+  Path      string `yaml:"path"`
+  Extractor string `yaml:"extractor"`
+  Pattern   string `yaml:"pattern"`
+  Unit      string `yaml:"unit"`
+  Direction string `yaml:"direction"`
+}
+
+// Maximize returns whether the objective's metric should be maximized rather
+// than minimized.
+func (o *JobObjective) Maximize() (bool, error) {
+  switch d := strings.ToLower(o.Direction); d {
+  case "", "min", "minimize", "minimise":
+    return false, nil
+  case "max", "maximize", "maximise":
+    return true, nil
+  }
+  return false, fmt.Errorf(
+    "Unknown direction for objective %s: %s", o.Metric, o.Direction,
+  )
+}
+
+// format returns the value of the metric with its unit
+func (o *JobObjective) format(val float64) string {
+  if len(o.Unit) == 0 {
+    return strconv.FormatFloat(val, 'g', -1, 64)
+  }
+
+  return fmt.Sprintf("%g %s", val, o.Unit)
+}
+
+// validate checks whether the objective can be measured
+func (o *JobObjective) validate() error {
+  if len(o.Metric) == 0 {
+    return fmt.Errorf("Objective has no metric")
+  }
+
+  if len(o.Path) == 0 {
+    return fmt.Errorf("Objective has no path: %s", o.Metric)
+  }
+
+  switch o.Extractor {
+  case "", "key":
+  case "regex":
+    re, err := regexp.Compile(o.Pattern)
+    if err != nil {
+      return fmt.Errorf("Invalid pattern for objective %s: %s", o.Metric, err)
+    }
+    if re.NumSubexp() > 1 {
+      return fmt.Errorf("Pattern for objective %s has more than one group", o.Metric)
+    }
+  case "json":
+    if len(o.Pattern) == 0 {
+      return fmt.Errorf("Objective %s has no JSON path", o.Metric)
+    }
+  default:
+    return fmt.Errorf(
+      "Unknown extractor for objective %s: %s", o.Metric, o.Extractor,
+    )
+  }
+
+  _, err := o.Maximize()
+  return err
+}
+
+// extract reads the objective's metric from the task's results directory
+// using the objective's extractor:
+//
+//  - key:   the file contains a line in the form of `metric=value` or
+//           `metric: value`, or the value on its own (default);
+//  - regex: the first group, or else the whole match, of the pattern;
+//  - json:  the value at the pattern's path in the JSON file.
+func (o *JobObjective) extract(resultsDir string) (float64, error) {
+  dat, err := ioutil.ReadFile(path.Join(resultsDir, o.Path))
+  if err != nil {
+    return 0, err
+  }
+
+  switch o.Extractor {
+  case "regex":
+    match := regexp.MustCompile(o.Pattern).FindStringSubmatch(string(dat))
+    if match == nil {
+      return 0, fmt.Errorf("Could not match %s in %s", o.Pattern, o.Path)
+    }
+    return strconv.ParseFloat(strings.TrimSpace(match[len(match) - 1]), 64)
+
+  case "json":
+    if !gjson.Valid(string(dat)) {
+      return 0, fmt.Errorf("Invalid JSON in %s", o.Path)
+    }
+    res := gjson.Get(string(dat), o.Pattern)
+    if !res.Exists() {
+      return 0, fmt.Errorf("Could not find %s in %s", o.Pattern, o.Path)
+    }
+    return strconv.ParseFloat(strings.TrimSpace(res.String()), 64)
+  }
+
+  re := regexp.MustCompile(
+    `^\s*` + regexp.QuoteMeta(o.Metric) + `\s*[:=]\s*(\S+)`,
+  )
+  for _, line := range strings.Split(string(dat), "\n") {
+    match := re.FindStringSubmatch(line)
+    if match != nil {
+      return strconv.ParseFloat(match[1], 64)
+    }
+  }
+
+  val, err := strconv.ParseFloat(strings.TrimSpace(string(dat)), 64)
+  if err != nil {
+    return 0, fmt.Errorf("Could not find metric %s in %s", o.Metric, o.Path)
+  }
+
+  return val, nil
+}
+
+// resolveObjectives checks that every declared objective can be measured and
+// that the objective optimised by the explorer is one of them.
+func (j *Job) resolveObjectives() error {
+  seen := make(map[string]bool)
+  for i := range j.Objectives {
+    err := j.Objectives[i].validate()
+    if err != nil {
+      return err
+    }
+
+    if seen[j.Objectives[i].Metric] {
+      return fmt.Errorf("Objective declared twice: %s", j.Objectives[i].Metric)
+    }
+    seen[j.Objectives[i].Metric] = true
+  }
+
+  if len(j.Explorer.Objective) > 0 && j.objective(j.Explorer.Objective) == nil {
+    return fmt.Errorf("Explorer objective is not declared in objectives: %s", j.Explorer.Objective)
+  }
+
+  return nil
+}
+
+// explorerObjective returns the objective which the explorer optimises, which
+// is the only declared objective unless the explorer names one
+func (j *Job) explorerObjective() *JobObjective {
+  if len(j.Explorer.Objective) > 0 {
+    return j.objective(j.Explorer.Objective)
+  } else if len(j.Objectives) == 1 {
+    return &j.Objectives[0]
+  }
+
+  return nil
+}
+
+// objective returns the declared objective of the metric, if there is one
+func (j *Job) objective(metric string) *JobObjective {
+  for i := range j.Objectives {
+    if j.Objectives[i].Metric == metric {
+      return &j.Objectives[i]
+    }
+  }
+
+  return nil
+}
+
+// measure extracts the value of each of the job's objectives from the task's
+// results and records them in the metrics file.  The value of a metric which
+// a run was repeated until, or which is in the outputs of a run repeated a
+// fixed number of times, is the mean over its repetitions.
+func (j *Job) measure(task *Task) {
+  if len(j.Objectives) == 0 {
+    return
+  }
+
+  if task.Metrics == nil {
+    task.Metrics = make(map[string]float64)
+  }
+
+  for _, objective := range j.Objectives {
+    val, err := task.repeatedMean(objective.Metric)
+    if err == errNotRepeated {
+      val, err = task.fixedRepetitionsMean(&objective)
+    }
+    if err == errNotRepeated {
+      val, err = objective.extract(task.resultsDir)
+    }
+    if err != nil {
+      log.Warnf("Could not measure %s of task %s: %s",
+        objective.Metric,
+        task.UUID(),
+        err,
+      )
+      continue
+    }
+
+    task.Metrics[objective.Metric] = val
+    log.Infof("Task %s has %s=%s",
+      task.UUID(),
+      objective.Metric,
+      objective.format(val),
+    )
+  }
+
+  j.updateBest(task)
+
+  // Keep track of the trade-off between multiple objectives
+  frontChanged := len(j.Objectives) > 1 && j.updateFront(task)
+
+  // A dry run does not write any results
+  if j.dryRun {
+    return
+  }
+
+  err := j.appendMetrics(task)
+  if err != nil {
+    log.Warnf("%s", err)
+  }
+
+  if frontChanged {
+    err = j.writeParetoFile()
+    if err != nil {
+      log.Warnf("%s", err)
+    }
+  }
+}
+
+// metricsRecord is the serialized form of the metrics of a measured task
+type metricsRecord struct {
+  UUID    string             `json:"uuid"`
+  Metrics map[string]float64 `json:"metrics"`
+}
+
+// appendMetrics appends the metrics of the task to the record of metrics
+func (j *Job) appendMetrics(task *Task) error {
+  b, err := json.Marshal(metricsRecord{
+    UUID:    task.UUID(),
+    Metrics: task.Metrics,
+  })
+  if err != nil {
+    return fmt.Errorf("Could not marshal JSON of metrics: %s", err)
+  }
+
+  err = appendLine(path.Join(j.workDir, "results", "metrics.jsonl"), b)
+  if err != nil {
+    return fmt.Errorf("Could not write metrics file: %s", err)
+  }
+
+  return nil
+}
+
+// bestTask is the task with the best value of an objective so far
+type bestTask struct {
+  uuid string
+  val  float64
+}
+
+// updateBest remembers the task for each objective it is the best task of
+func (j *Job) updateBest(task *Task) {
+  if j.best == nil {
+    j.best = make(map[string]bestTask)
+  }
+
+  for _, objective := range j.Objectives {
+    val, ok := task.Metrics[objective.Metric]
+    if !ok {
+      continue
+    }
+
+    maximize, _ := objective.Maximize()
+    best, ok := j.best[objective.Metric]
+    if !ok || (maximize && val > best.val) || (!maximize && val < best.val) {
+      j.best[objective.Metric] = bestTask{task.UUID(), val}
+    }
+  }
+}
+
+// summarize logs the best measured task of each objective and the tasks on the
+// Pareto front of multiple objectives.
+func (j *Job) summarize() {
+  if j.pruned > 0 {
+    log.Infof("Pruned %d tasks which do not satisfy the constraints", j.pruned)
+  }
+
+  if len(j.Objectives) > 1 {
+    log.Successf("There are %d tasks on the Pareto front", len(j.front))
+    for _, record := range j.front {
+      var metrics []string
+      for _, objective := range j.Objectives {
+        metrics = append(metrics, fmt.Sprintf("%s=%s",
+          objective.Metric,
+          objective.format(record.Metrics[objective.Metric]),
+        ))
+      }
+      log.Successf("%s: %s", record.Task, strings.Join(metrics, ", "))
+    }
+  }
+
+  for _, objective := range j.Objectives {
+    if best, ok := j.best[objective.Metric]; ok {
+      log.Successf("Best task for %s is %s with %s",
+        objective.Metric,
+        best.uuid,
+        objective.format(best.val),
+      )
+    }
+  }
+}
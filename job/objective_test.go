@@ -0,0 +1,133 @@
+package job
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "os"
+  "path"
+  "testing"
+  "io/ioutil"
+
+  "github.com/lancs-net/wayfinder/run"
+)
+
+// TestMeasureRepeated checks that the metric of a run which is repeated a
+// fixed number of times is the mean over the outputs of its repetitions
+func TestMeasureRepeated(t *testing.T) {
+  workDir, err := ioutil.TempDir("", "wayfinder-measure")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(workDir)
+
+  j := &Job{
+    workDir:    workDir,
+    Objectives: []JobObjective{{Metric: "rps", Path: "results.txt"}},
+  }
+
+  task := &Task{
+    runs:       []run.Run{{Name: "measure", Repeat: 3}},
+    deps:       [][]int{nil},
+    states:     [][]int{{runSucceeded, runSucceeded, runFailed}},
+    uuid:       "task",
+    resultsDir: path.Join(workDir, "results", "task"),
+  }
+
+  // The failed repetition is not part of the mean
+  for rep, val := range []string{"rps=10", "rps=20", "rps=1000"} {
+    dir := task.outputsDir(runInstance{0, rep + 1})
+    if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+      t.Fatal(err)
+    }
+
+    err := ioutil.WriteFile(path.Join(dir, "results.txt"), []byte(val), 0644)
+    if err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  j.measure(task)
+
+  if val, ok := task.Metrics["rps"]; !ok || val != 15 {
+    t.Errorf("Got rps=%g (%t), expected 15", val, ok)
+  }
+
+  if _, err := os.Stat(path.Join(workDir, "results", "metrics.jsonl")); err != nil {
+    t.Errorf("Metrics were not recorded: %s", err)
+  }
+}
+
+// TestMeasureDryRun checks that a dry run measures tasks without writing any
+// results
+func TestMeasureDryRun(t *testing.T) {
+  workDir, err := ioutil.TempDir("", "wayfinder-measure")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(workDir)
+
+  j := &Job{
+    workDir:    workDir,
+    dryRun:     true,
+    Objectives: []JobObjective{
+      {Metric: "rps", Path: "rps.txt"},
+      {Metric: "mem", Path: "mem.txt"},
+    },
+  }
+
+  task := &Task{
+    uuid:       "task",
+    resultsDir: path.Join(workDir, "results", "task"),
+  }
+
+  if err := os.MkdirAll(task.resultsDir, os.ModePerm); err != nil {
+    t.Fatal(err)
+  }
+
+  for file, val := range map[string]string{"rps.txt": "10", "mem.txt": "20"} {
+    err := ioutil.WriteFile(path.Join(task.resultsDir, file), []byte(val), 0644)
+    if err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  j.measure(task)
+
+  if len(task.Metrics) != 2 || len(j.front) != 1 {
+    t.Errorf("Got metrics %v and front of %d tasks", task.Metrics, len(j.front))
+  }
+
+  for _, file := range []string{"metrics.jsonl", "pareto.json"} {
+    if _, err := os.Stat(path.Join(workDir, "results", file)); err == nil {
+      t.Errorf("Dry run wrote %s", file)
+    }
+  }
+}
@@ -0,0 +1,275 @@
+package job
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "fmt"
+  "sort"
+  "time"
+  "math/rand"
+
+  "github.com/lancs-net/wayfinder/log"
+)
+
+const (
+  OrderSequential   = "sequential"
+  OrderShuffle      = "shuffle"
+  OrderInterleave   = "interleave-repetitions"
+  OrderBreadthFirst = "breadth-first"
+  OrderDepthFirst   = "depth-first"
+)
+
+// Number of tasks which wait to be picked by the breadth-first order, such
+// that the runs early in their graphs are started before those later on
+const defaultBreadthFirstWindow = 256
+
+// Largest space whose tasks can be generated in a random order
+const maxShuffleSize = 1 << 62
+
+// JobOrder selects the order in which the scheduler picks the runs of the
+// waiting tasks which are ready to be started.
+type JobOrder struct {
+  Policy string `yaml:"policy"`
+  Seed   int64  `yaml:"seed"`
+  Window int    `yaml:"window"`
+}
+
+// validate checks whether the order's policy is known
+func (o *JobOrder) validate() error {
+  if o.Window < 0 {
+    return fmt.Errorf("Order window cannot be negative: %d", o.Window)
+  }
+
+  switch o.Policy {
+  case "", OrderSequential, OrderShuffle, OrderInterleave, OrderBreadthFirst, OrderDepthFirst:
+    return nil
+  }
+
+  return fmt.Errorf("Unknown order policy: %s", o.Policy)
+}
+
+// resolve sets the default policy, which is sequential, and picks a seed when
+// shuffling without one so that the same order is used throughout the job
+func (o *JobOrder) resolve() {
+  if len(o.Policy) == 0 {
+    o.Policy = OrderSequential
+  }
+
+  if o.Policy == OrderShuffle {
+    if o.Seed == 0 {
+      o.Seed = time.Now().UnixNano()
+    }
+
+    log.Infof("Using %s order with seed %d", o.Policy, o.Seed)
+  } else {
+    log.Debugf("Using %s order", o.Policy)
+  }
+
+  if o.Window == 0 && o.Policy == OrderBreadthFirst {
+    o.Window = defaultBreadthFirstWindow
+  }
+}
+
+// candidate is a repetition of a run of a waiting task which is ready
+type candidate struct {
+  task  *Task
+  ri    runInstance
+  depth  int // of the run in the graph of runs
+}
+
+// runDepths returns the length of the longest chain of dependencies which
+// leads up to each run
+func runDepths(deps [][]int) []int {
+  depths := make([]int, len(deps))
+
+  var visit func(i int) int
+  visit = func(i int) int {
+    if depths[i] > 0 || len(deps[i]) == 0 {
+      return depths[i]
+    }
+
+    for _, k := range deps[i] {
+      if d := visit(k) + 1; d > depths[i] {
+        depths[i] = d
+      }
+    }
+
+    return depths[i]
+  }
+
+  for i := range deps {
+    visit(i)
+  }
+
+  return depths
+}
+
+// orderer sorts the runs which are ready according to the job's policy
+type orderer struct {
+  policy string
+  window int // minimum number of waiting tasks to pick runs from
+  rand  *rand.Rand
+}
+
+// newOrderer prepares the job's resolved order policy
+func newOrderer(cfg JobOrder) *orderer {
+  o := &orderer{
+    policy: cfg.Policy,
+    window: cfg.Window,
+  }
+
+  if o.policy == OrderShuffle {
+    o.rand = rand.New(rand.NewSource(cfg.Seed))
+  }
+
+  return o
+}
+
+// lookahead returns the number of tasks which should be waiting to pick runs
+// from, which is at least the number of free cores
+func (o *orderer) lookahead(free int) int {
+  if o.window > free {
+    return o.window
+  }
+
+  return free
+}
+
+// sort orders the candidates, which are given in the order of the wait list:
+//
+//  - sequential:             as they are in the wait list;
+//  - shuffle:                at random, whilst the grid also proposes its
+//                            tasks in a random order;
+//  - interleave-repetitions: earlier repetitions of any task first;
+//  - breadth-first:          runs earlier in the graph of runs of any task
+//                            first, e.g. all builds before any test;
+//  - depth-first:            runs later in the graph of runs first, such that
+//                            tasks which have started are finished first.
+func (o *orderer) sort(candidates []candidate) {
+  switch o.policy {
+  case OrderShuffle:
+    o.rand.Shuffle(len(candidates), func(a, b int) {
+      candidates[a], candidates[b] = candidates[b], candidates[a]
+    })
+
+  case OrderInterleave:
+    sort.SliceStable(candidates, func(a, b int) bool {
+      return candidates[a].ri.rep < candidates[b].ri.rep
+    })
+
+  case OrderBreadthFirst:
+    sort.SliceStable(candidates, func(a, b int) bool {
+      if candidates[a].depth != candidates[b].depth {
+        return candidates[a].depth < candidates[b].depth
+      }
+      return candidates[a].ri.rep < candidates[b].ri.rep
+    })
+
+  case OrderDepthFirst:
+    sort.SliceStable(candidates, func(a, b int) bool {
+      if candidates[a].depth != candidates[b].depth {
+        return candidates[a].depth > candidates[b].depth
+      }
+      return candidates[a].ri.rep > candidates[b].ri.rep
+    })
+  }
+}
+
+// permutation lazily iterates over a seeded random permutation of [0, n)
+// without keeping it in memory.  Each value of a domain of at most 4n values
+// is encrypted with a small Feistel network, which is a bijection of the
+// domain, and the encrypted values which are out of range are skipped.
+type permutation struct {
+  n     uint64
+  i     uint64 // next value of the domain to encrypt
+  half  uint   // number of bits of each half of the domain
+  keys  []uint64
+}
+
+// Number of rounds of the Feistel network
+const permutationRounds = 4
+
+// newPermutation returns a permutation of [0, n) which depends on the seed
+func newPermutation(n uint64, seed int64) *permutation {
+  p := &permutation{
+    n:    n,
+    half: 1,
+    keys: make([]uint64, permutationRounds),
+  }
+
+  for uint64(1) << (2 * p.half) < n {
+    p.half++
+  }
+
+  r := rand.New(rand.NewSource(seed))
+  for k := range p.keys {
+    p.keys[k] = r.Uint64()
+  }
+
+  return p
+}
+
+// next returns the next value of the permutation, or false once all of them
+// have been returned
+func (p *permutation) next() (uint64, bool) {
+  size := uint64(1) << (2 * p.half)
+  for p.i < size {
+    v := p.encrypt(p.i)
+    p.i++
+    if v < p.n {
+      return v, true
+    }
+  }
+
+  return 0, false
+}
+
+// encrypt maps the value of the domain onto another one
+func (p *permutation) encrypt(v uint64) uint64 {
+  mask := uint64(1) << p.half - 1
+  l, r := v >> p.half, v & mask
+
+  for _, key := range p.keys {
+    l, r = r, l ^ (mix(r ^ key) & mask)
+  }
+
+  return l << p.half | r
+}
+
+// mix scrambles the bits of x (the finalizer of SplitMix64)
+func mix(x uint64) uint64 {
+  x ^= x >> 30
+  x *= 0xbf58476d1ce4e5b9
+  x ^= x >> 27
+  x *= 0x94d049bb133111eb
+  x ^= x >> 31
+  return x
+}
@@ -0,0 +1,107 @@
+package job
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "fmt"
+  "testing"
+)
+
+// TestPermutation checks that the permutation visits every index exactly once,
+// whether or not the size is a power of two, and depends only on the seed
+func TestPermutation(t *testing.T) {
+  for _, n := range []uint64{1, 2, 7, 1000} {
+    visit := func(seed int64) []uint64 {
+      var order []uint64
+      seen := make(map[uint64]bool)
+
+      p := newPermutation(n, seed)
+      for {
+        i, ok := p.next()
+        if !ok {
+          break
+        }
+
+        if i >= n || seen[i] {
+          t.Fatalf("Permutation of %d visited %d more than once or out of range", n, i)
+        }
+
+        seen[i] = true
+        order = append(order, i)
+      }
+
+      if uint64(len(order)) != n {
+        t.Errorf("Permutation of %d visited %d indices", n, len(order))
+      }
+
+      return order
+    }
+
+    if fmt.Sprint(visit(42)) != fmt.Sprint(visit(42)) {
+      t.Errorf("Permutation of %d differs with the same seed", n)
+    }
+
+    if n == 1000 && fmt.Sprint(visit(42)) == fmt.Sprint(visit(43)) {
+      t.Errorf("Permutation of %d is the same with different seeds", n)
+    }
+  }
+}
+
+// TestOrderWindow checks how many tasks wait to be picked from by each policy
+func TestOrderWindow(t *testing.T) {
+  tests := []struct {
+    order  JobOrder
+    free   int
+    expect int
+  }{
+    {JobOrder{}, 8, 8},
+    {JobOrder{Policy: OrderShuffle, Seed: 1}, 8, 8},
+    {JobOrder{Policy: OrderBreadthFirst}, 8, defaultBreadthFirstWindow},
+    {JobOrder{Policy: OrderBreadthFirst}, 512, 512},
+    {JobOrder{Policy: OrderBreadthFirst, Window: 16}, 8, 16},
+    {JobOrder{Policy: OrderDepthFirst, Window: 16}, 32, 32},
+  }
+
+  for _, test := range tests {
+    order := test.order
+    order.resolve()
+
+    if got := newOrderer(order).lookahead(test.free); got != test.expect {
+      t.Errorf("%+v with %d free cores: got %d, expected %d", test.order, test.free, got, test.expect)
+    }
+  }
+
+  order := JobOrder{}
+  order.resolve()
+  if order.Policy != OrderSequential {
+    t.Errorf("Got default policy %s, expected %s", order.Policy, OrderSequential)
+  }
+}
@@ -0,0 +1,211 @@
+package job
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "fmt"
+  "math"
+  "path"
+  "sort"
+  "io/ioutil"
+  "encoding/json"
+
+  "github.com/lancs-net/wayfinder/log"
+)
+
+// dominates returns whether a is no worse than b in every objective and better
+// in at least one, where all objectives are minimized.
+func dominates(a, b []float64) bool {
+  better := false
+  for i := range a {
+    if a[i] > b[i] {
+      return false
+    } else if a[i] < b[i] {
+      better = true
+    }
+  }
+
+  return better
+}
+
+// nondominatedSort sorts the points into successive Pareto fronts, returning
+// the indices of the points in each front.
+func nondominatedSort(points [][]float64) [][]int {
+  var fronts [][]int
+
+  dominatedBy := make([]int, len(points))
+  dominating := make([][]int, len(points))
+
+  var front []int
+  for i := range points {
+    for j := range points {
+      if dominates(points[i], points[j]) {
+        dominating[i] = append(dominating[i], j)
+      } else if dominates(points[j], points[i]) {
+        dominatedBy[i]++
+      }
+    }
+
+    if dominatedBy[i] == 0 {
+      front = append(front, i)
+    }
+  }
+
+  for len(front) > 0 {
+    fronts = append(fronts, front)
+
+    var next []int
+    for _, i := range front {
+      for _, j := range dominating[i] {
+        dominatedBy[j]--
+        if dominatedBy[j] == 0 {
+          next = append(next, j)
+        }
+      }
+    }
+
+    front = next
+  }
+
+  return fronts
+}
+
+// crowdingDistance returns the distance of each point of the front to its
+// neighbours in the objective space.  The extremes of the front have an
+// infinite distance.
+func crowdingDistance(points [][]float64, front []int) []float64 {
+  distance := make([]float64, len(front))
+  if len(front) == 0 {
+    return distance
+  }
+
+  order := make([]int, len(front))
+  for m := range points[front[0]] {
+    for i := range order {
+      order[i] = i
+    }
+
+    sort.SliceStable(order, func(a, b int) bool {
+      return points[front[order[a]]][m] < points[front[order[b]]][m]
+    })
+
+    min := points[front[order[0]]][m]
+    max := points[front[order[len(order) - 1]]][m]
+
+    distance[order[0]] = math.Inf(1)
+    distance[order[len(order) - 1]] = math.Inf(1)
+
+    if max == min || math.IsInf(max - min, 0) {
+      continue
+    }
+
+    for i := 1; i < len(order) - 1; i++ {
+      prev := points[front[order[i - 1]]][m]
+      next := points[front[order[i + 1]]][m]
+      distance[order[i]] += (next - prev) / (max - min)
+    }
+  }
+
+  return distance
+}
+
+// objectiveValues returns the value of each objective in the metrics such that
+// all objectives are minimized, or false if any of the values is missing.
+func objectiveValues(metrics map[string]float64, objectives []JobObjective) ([]float64, bool) {
+  values := make([]float64, len(objectives))
+  for i, objective := range objectives {
+    val, ok := metrics[objective.Metric]
+    if !ok {
+      return nil, false
+    }
+
+    if maximize, _ := objective.Maximize(); maximize {
+      val = -val
+    }
+
+    values[i] = val
+  }
+
+  return values, true
+}
+
+// paretoRecord is the serialized form of a task on the Pareto front
+type paretoRecord struct {
+  Task    string             `json:"task"`
+  Params  interface{}        `json:"params"`
+  Metrics map[string]float64 `json:"metrics"`
+}
+
+// updateFront adds the task to the Pareto front of the measured tasks unless
+// it is dominated, removing the tasks it dominates.  It returns whether the
+// front has changed.
+func (j *Job) updateFront(task *Task) bool {
+  values, ok := objectiveValues(task.Metrics, j.Objectives)
+  if !ok {
+    return false
+  }
+
+  var front []paretoRecord
+  for _, record := range j.front {
+    other, _ := objectiveValues(record.Metrics, j.Objectives)
+    if dominates(other, values) {
+      return false
+    } else if !dominates(values, other) {
+      front = append(front, record)
+    }
+  }
+
+  j.front = append(front, paretoRecord{
+    Task:    task.UUID(),
+    Params:  task.paramsMap(),
+    Metrics: task.Metrics,
+  })
+
+  return true
+}
+
+// writeParetoFile writes the tasks on the Pareto front with their parameters
+// and metrics.
+func (j *Job) writeParetoFile() error {
+  b, err := json.MarshalIndent(j.front, "", "\t")
+  if err != nil {
+    return fmt.Errorf("Could not marshal JSON of Pareto front: %s", err)
+  }
+
+  paretoJsonFile := path.Join(j.workDir, "results", "pareto.json")
+  log.Debugf("Writing Pareto front file %s...", paretoJsonFile)
+  err = ioutil.WriteFile(paretoJsonFile, b, 0644)
+  if err != nil {
+    return fmt.Errorf("Could not write Pareto front file: %s", err)
+  }
+
+  return nil
+}
@@ -0,0 +1,103 @@
+package job
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "math"
+  "reflect"
+  "testing"
+)
+
+// paretoPoints are minimized in both objectives, with the fronts [0 1 2], [3],
+// [5] and [4]
+var paretoPoints = [][]float64{
+  {1, 5},
+  {2, 3},
+  {4, 1},
+  {3, 4},
+  {5, 5},
+  {4, 4},
+}
+
+// TestDominates checks whether a point is no worse in every objective and
+// better in at least one
+func TestDominates(t *testing.T) {
+  tests := []struct {
+    a      []float64
+    b      []float64
+    expect bool
+  }{
+    {[]float64{1, 1}, []float64{2, 2}, true},
+    {[]float64{1, 2}, []float64{2, 2}, true},
+    {[]float64{2, 2}, []float64{2, 2}, false},
+    {[]float64{1, 3}, []float64{2, 2}, false},
+    {[]float64{2, 2}, []float64{1, 1}, false},
+    {[]float64{1, 1}, []float64{math.Inf(1), 1}, true},
+  }
+
+  for _, test := range tests {
+    if got := dominates(test.a, test.b); got != test.expect {
+      t.Errorf("%v dominates %v: got %t, expected %t", test.a, test.b, got, test.expect)
+    }
+  }
+}
+
+// TestNondominatedSort checks the Pareto fronts of the points
+func TestNondominatedSort(t *testing.T) {
+  fronts := nondominatedSort(paretoPoints)
+  expect := [][]int{{0, 1, 2}, {3}, {5}, {4}}
+
+  if !reflect.DeepEqual(fronts, expect) {
+    t.Errorf("Got fronts %v, expected %v", fronts, expect)
+  }
+}
+
+// TestCrowdingDistance checks the distance of the points of a front to their
+// neighbours, normalized by the range of each objective
+func TestCrowdingDistance(t *testing.T) {
+  tests := []struct {
+    points [][]float64
+    front  []int
+    expect []float64
+  }{
+    {paretoPoints, []int{0, 1, 2}, []float64{math.Inf(1), 2, math.Inf(1)}},
+    {paretoPoints, []int{3}, []float64{math.Inf(1)}},
+    {[][]float64{{1, 1}, {1, 1}, {1, 1}}, []int{0, 1, 2}, []float64{math.Inf(1), 0, math.Inf(1)}},
+    {[][]float64{{0, 4}, {1, 3}, {3, 1}, {4, 0}}, []int{0, 1, 2, 3}, []float64{math.Inf(1), 1.5, 1.5, math.Inf(1)}},
+  }
+
+  for _, test := range tests {
+    distance := crowdingDistance(test.points, test.front)
+    if !reflect.DeepEqual(distance, test.expect) {
+      t.Errorf("Crowding distance of %v: got %v, expected %v", test.front, distance, test.expect)
+    }
+  }
+}
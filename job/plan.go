@@ -0,0 +1,496 @@
+package job
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "fmt"
+  "path"
+  "time"
+  "strings"
+  "container/heap"
+  "path/filepath"
+
+  "github.com/lancs-net/wayfinder/log"
+)
+
+// PlanRun describes one of the runs of each task in a plan
+type PlanRun struct {
+  Name        string
+  Cores       int
+  Repeat      int
+  Concurrency int           // number of these runs which fit on the CPUs at once
+  Duration    time.Duration // estimated duration, zero if unknown
+  Source      string        // where the estimated duration comes from
+}
+
+// Plan describes what the job would do without running it
+type Plan struct {
+  Params      []string      // names of the parameters in order
+  Count       int           // number of tasks after constraints
+  Size        int64         // number of permutations before constraints
+  Pruned      int           // number of tasks pruned by constraints
+  Budget      int           // maximum number of tasks the explorer proposes
+  Explorer    string
+  Runs      []PlanRun
+  Cpus        int
+  Concurrency int           // maximum number of runs at once
+  Makespan    time.Duration // estimated duration of the job, zero if unknown
+  job        *Job
+  deps      [][]int
+  cpus      []int
+  topology   *Topology
+  reserve     bool          // whether siblings of allocated CPUs are kept idle
+}
+
+// runHistory holds the durations of runs of previous jobs in seconds
+type runHistory struct {
+  tasks map[string]map[string]float64 // by task and run
+  runs  map[string][]float64          // by run
+}
+
+// NewPlan counts the tasks of the job and estimates how long it would take to
+// run them on the CPUs, without preparing the environment.  Tasks are not kept
+// in memory but iterated over again when needed.
+func NewPlan(filePath string, cfg *RuntimeConfig) (*Plan, []error) {
+  j, errs := loadJob(filePath)
+  if j == nil {
+    return nil, errs
+  }
+
+  j.workDir = cfg.WorkDir
+  j.overrideRepeat(cfg.Repeat)
+
+  errs = append(errs, j.validate(cfg)...)
+  if len(errs) > 0 {
+    return nil, errs
+  }
+
+  dims, err := j.dimensions()
+  if err != nil {
+    return nil, []error{err}
+  }
+
+  plan := &Plan{
+    Size:     spaceSize(dims),
+    Explorer: j.Explorer.Type,
+    Cpus:     len(cfg.Cpus),
+    job:      j,
+    cpus:     cfg.Cpus,
+    reserve:  cfg.ReserveSiblings,
+  }
+
+  // Runs are placed on the CPUs the way the scheduler would place them
+  plan.topology, err = j.readTopology(cfg)
+  if err != nil {
+    return nil, []error{err}
+  }
+
+  if plan.Explorer == "" {
+    plan.Explorer = "grid"
+  }
+
+  for _, param := range j.Params {
+    plan.Params = append(plan.Params, param.Name)
+  }
+
+  plan.Count, plan.Pruned, err = j.countTasks()
+  if err != nil {
+    return nil, []error{err}
+  }
+
+  log.Infof("There are %d tasks after constraints, %d were pruned", plan.Count, plan.Pruned)
+
+  // Explorers other than the grid only propose some of the tasks
+  plan.Budget = plan.Count
+  budget := 0
+  switch plan.Explorer {
+  case "random", "bayesian":
+    budget = j.Explorer.Budget
+  case "genetic", "nsga2":
+    budget = j.Explorer.Population * j.Explorer.Generations
+  }
+  if budget > 0 && budget < plan.Budget {
+    plan.Budget = budget
+  }
+
+  history, err := readHistory(j.workDir)
+  if err != nil {
+    return nil, []error{err}
+  }
+
+  for _, r := range j.Runs {
+    pr := PlanRun{
+      Name:        r.Name,
+      Cores:       r.Cores,
+      Repeat:      repeats(r),
+      Concurrency: plan.topology.concurrency(cfg.Cpus, r.Cores, cfg.ReserveSiblings),
+    }
+
+    if durations, ok := history.runs[r.Name]; ok {
+      sum := 0.0
+      for _, d := range durations {
+        sum += d
+      }
+      pr.Duration = seconds(sum / float64(len(durations)))
+      pr.Source = fmt.Sprintf("mean of %d previous runs", len(durations))
+    } else if len(r.Duration) > 0 {
+      pr.Duration, _ = time.ParseDuration(r.Duration)
+      pr.Source = "duration of run"
+    }
+
+    if pr.Concurrency > plan.Concurrency {
+      plan.Concurrency = pr.Concurrency
+    }
+
+    plan.Runs = append(plan.Runs, pr)
+  }
+
+  // Only the runs of a task which do not depend on each other run at once
+  plan.deps = runDependencies(j.Runs)
+  if width := plan.Budget * dagWidth(plan.deps); width < plan.Concurrency {
+    plan.Concurrency = width
+  }
+
+  plan.Makespan, err = plan.simulate(history)
+  if err != nil {
+    return nil, []error{err}
+  }
+
+  return plan, nil
+}
+
+// EachTask calls visit with the parameters of each of the job's tasks after
+// constraints, in order, without keeping them in memory
+func (p *Plan) EachTask(visit func(i int, params []TaskParam)) error {
+  iter, err := p.job.iterator()
+  if err != nil {
+    return err
+  }
+
+  for i := 0; ; i++ {
+    task, err := iter.Next()
+    if err != nil {
+      return err
+    }
+
+    // Pruned tasks are only counted, they are not recorded
+    p.job.prunedQueue = nil
+
+    if task == nil {
+      return nil
+    }
+
+    visit(i, task.Params)
+  }
+}
+
+// dagWidth returns the largest number of runs which are equally far from the
+// runs without dependencies, as an estimate of how many runs of a task can run
+// at once
+func dagWidth(deps [][]int) int {
+  depth := make([]int, len(deps))
+  var measure func(i int) int
+  measure = func(i int) int {
+    if depth[i] == 0 {
+      depth[i] = 1
+      for _, d := range deps[i] {
+        if measure(d) + 1 > depth[i] {
+          depth[i] = depth[d] + 1
+        }
+      }
+    }
+    return depth[i]
+  }
+
+  count := make(map[int]int)
+  width := 0
+  for i := range deps {
+    count[measure(i)]++
+    if count[depth[i]] > width {
+      width = count[depth[i]]
+    }
+  }
+
+  return width
+}
+
+// seconds converts seconds to a duration
+func seconds(s float64) time.Duration {
+  return time.Duration(s * float64(time.Second))
+}
+
+// readHistory collects the durations of runs from the manifests of the tasks
+// in the results of previous jobs in the working directory
+func readHistory(workDir string) (*runHistory, error) {
+  history := &runHistory{
+    tasks: make(map[string]map[string]float64),
+    runs:  make(map[string][]float64),
+  }
+
+  manifests, err := filepath.Glob(path.Join(workDir, "results", "*", "manifest.json"))
+  if err != nil {
+    return nil, fmt.Errorf("Could not find previous results: %s", err)
+  }
+
+  for _, manifest := range manifests {
+    m, err := readManifest(path.Dir(manifest))
+    if err != nil {
+      return nil, err
+    }
+
+    if m == nil || len(m.Durations) == 0 {
+      continue
+    }
+
+    // Repetitions of a run are recorded as <run>/<rep>
+    sums := make(map[string]float64)
+    counts := make(map[string]int)
+    for name, d := range m.Durations {
+      name = strings.SplitN(name, "/", 2)[0]
+      sums[name] += d
+      counts[name]++
+      history.runs[name] = append(history.runs[name], d)
+    }
+
+    history.tasks[m.UUID] = make(map[string]float64)
+    for name, sum := range sums {
+      history.tasks[m.UUID][name] = sum / float64(counts[name])
+    }
+  }
+
+  return history, nil
+}
+
+// simTask is a task whose runs are being simulated
+type simTask struct {
+  durations map[string]float64 // of its runs in previous jobs
+  started   []int // repetitions of each run which were started
+  done      []int // repetitions of each run which finished
+}
+
+// simRun is a run which is being simulated until it finishes
+type simRun struct {
+  task   *simTask
+  run    int
+  cpus []int // allocated to the run, including idle siblings
+  finish time.Duration
+  seq    int // order in which runs were started
+}
+
+// simEvents is a heap of the runs in flight by when they finish
+type simEvents []simRun
+
+func (h simEvents) Len() int { return len(h) }
+func (h simEvents) Swap(a, b int) { h[a], h[b] = h[b], h[a] }
+func (h simEvents) Less(a, b int) bool {
+  if h[a].finish != h[b].finish {
+    return h[a].finish < h[b].finish
+  }
+  return h[a].seq < h[b].seq
+}
+func (h *simEvents) Push(x interface{}) { *h = append(*h, x.(simRun)) }
+func (h *simEvents) Pop() interface{} {
+  old := *h
+  x := old[len(old) - 1]
+  *h = old[:len(old) - 1]
+  return x
+}
+
+// simulate schedules the runs of the tasks the way the job would and returns
+// when the last one finishes.  Runs of a task take as long as they did before,
+// otherwise their estimated duration.  The makespan is unknown if any of the
+// runs have no estimate.  Runs are allocated CPUs according to the topology,
+// like the scheduler does.  Only the tasks which have runs in flight or waiting
+// are kept, and finishing runs are taken from a heap.
+func (p *Plan) simulate(history *runHistory) (time.Duration, error) {
+  iter, err := p.job.iterator()
+  if err != nil {
+    return 0, err
+  }
+
+  var now time.Duration
+  var active []*simTask
+  var events simEvents
+  admitted := 0
+  seq := 0
+  busy := make(map[int]bool)
+  order := newOrderer(p.job.Order)
+  unknown := false
+
+  // free returns the CPUs which are not allocated to any run
+  free := func() []int {
+    var cpus []int
+    for _, id := range p.cpus {
+      if !busy[id] {
+        cpus = append(cpus, id)
+      }
+    }
+    return cpus
+  }
+
+  // start starts every run of the task whose dependencies are done, in order,
+  // for as long as cores are free, and returns how many were started
+  start := func(t *simTask) int {
+    n := 0
+    for k, r := range p.Runs {
+      // Repetitions of a run are not run at the same time
+      if t.started[k] > t.done[k] || t.started[k] == r.Repeat {
+        continue
+      }
+
+      ready := true
+      for _, d := range p.deps[k] {
+        if t.done[d] < p.Runs[d].Repeat {
+          ready = false
+        }
+      }
+      if !ready {
+        continue
+      }
+
+      d := r.Duration
+      if s, ok := t.durations[r.Name]; ok {
+        d = seconds(s)
+      }
+      if d == 0 {
+        unknown = true
+        return n
+      }
+
+      cores, reserved, _ := p.topology.allocate(free(), r.Cores, p.reserve)
+      if cores == nil {
+        continue
+      }
+
+      cpus := append(cores, reserved...)
+      for _, id := range cpus {
+        busy[id] = true
+      }
+
+      t.started[k]++
+      heap.Push(&events, simRun{t, k, cpus, now + d, seq})
+      seq++
+      n++
+    }
+
+    return n
+  }
+
+  for {
+    for _, t := range active {
+      if len(free()) == 0 || unknown {
+        break
+      }
+      start(t)
+    }
+
+    // Like the scheduler, take on the next tasks only while there are fewer
+    // tasks without runs in flight than the order policy looks ahead.  All tasks have the same
+    // runs, so if none of the next task's runs fit, neither do the next ones'.
+    waiting := 0
+    for _, t := range active {
+      if t.inFlight() == 0 {
+        waiting++
+      }
+    }
+
+    for n := len(free()); n > 0 && waiting < order.lookahead(n) && !unknown && admitted < p.Budget; n = len(free()) {
+      task, err := iter.Next()
+      p.job.prunedQueue = nil
+      if err != nil {
+        return 0, err
+      }
+      if task == nil {
+        break
+      }
+
+      t := &simTask{
+        durations: history.tasks[task.UUID()],
+        started:   make([]int, len(p.Runs)),
+        done:      make([]int, len(p.Runs)),
+      }
+      active = append(active, t)
+      admitted++
+
+      if start(t) == 0 {
+        waiting++
+        break
+      }
+    }
+
+    if unknown {
+      return 0, nil
+    } else if events.Len() == 0 {
+      break
+    }
+
+    // Advance to the run which finishes first
+    finished := heap.Pop(&events).(simRun)
+    now = finished.finish
+    for _, id := range finished.cpus {
+      delete(busy, id)
+    }
+    finished.task.done[finished.run]++
+
+    // Forget the task once all of its runs have finished
+    if finished.task.finished(p.Runs) {
+      for i, t := range active {
+        if t == finished.task {
+          active = append(active[:i], active[i+1:]...)
+          break
+        }
+      }
+    }
+  }
+
+  return now, nil
+}
+
+// inFlight returns the number of runs of the task which are running
+func (t *simTask) inFlight() int {
+  n := 0
+  for k := range t.started {
+    n += t.started[k] - t.done[k]
+  }
+
+  return n
+}
+
+// finished returns whether all repetitions of all runs of the task finished
+func (t *simTask) finished(runs []PlanRun) bool {
+  for k, r := range runs {
+    if t.done[k] < r.Repeat {
+      return false
+    }
+  }
+
+  return true
+}
@@ -33,6 +33,7 @@ package job
 import (
   "os"
   "fmt"
+  "strconv"
   "strings"
   "io/ioutil"
 
@@ -243,6 +244,30 @@ func PrepareEnvironment(cpus []int, dryRun bool) error {
   return nil
 }
 
+// hostMemory returns the total memory of the host in bytes
+func hostMemory() (int64, error) {
+  dat, err := ioutil.ReadFile("/proc/meminfo")
+  if err != nil {
+    return 0, fmt.Errorf("Could not read memory info: %s", err)
+  }
+
+  for _, line := range strings.Split(string(dat), "\n") {
+    fields := strings.Fields(line)
+    if len(fields) < 2 || fields[0] != "MemTotal:" {
+      continue
+    }
+
+    kb, err := strconv.ParseInt(fields[1], 10, 64)
+    if err != nil {
+      return 0, fmt.Errorf("Could not parse total memory: %s", line)
+    }
+
+    return kb * 1024, nil
+  }
+
+  return 0, fmt.Errorf("Could not find total memory in /proc/meminfo")
+}
+
 // RevertEnvironment sets original Procfs entries 
 func RevertEnvironment(dryRun bool) error {
   // Reset updated procfs itemss
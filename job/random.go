@@ -0,0 +1,207 @@
+package job
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "fmt"
+  "time"
+  "math/rand"
+
+  "github.com/lancs-net/wayfinder/log"
+)
+
+// randomAttempts is the number of times a random permutation is sampled before
+// assuming no more permutations which have not yet been seen can be found.
+const randomAttempts = 10000
+
+// RandomExplorer samples permutations uniformly at random from the job's
+// parameter space until its budget of tasks has been proposed.
+type RandomExplorer struct {
+  job      *Job
+  dims   []dimension
+  rand     *rand.Rand
+  seed      int64
+  budget    int
+  proposed  int
+  seen      map[string]bool
+  perm    []int
+  cursor    int
+}
+
+// NewRandomExplorer creates a random explorer with the seed and budget set in
+// the explorer configuration.
+func NewRandomExplorer(cfg *JobExplorer) (*RandomExplorer, error) {
+  if cfg.Budget <= 0 {
+    return nil, fmt.Errorf("Random explorer requires a budget greater than 0")
+  }
+
+  seed := cfg.Seed
+  if seed == 0 {
+    seed = time.Now().UnixNano()
+  }
+
+  return &RandomExplorer{
+    seed:   seed,
+    budget: cfg.Budget,
+  }, nil
+}
+
+// Init determines the values of each parameter which can be sampled
+func (e *RandomExplorer) Init(job *Job) error {
+  dims, err := job.dimensions()
+  if err != nil {
+    return err
+  }
+
+  e.job = job
+  e.dims = dims
+  e.rand = rand.New(rand.NewSource(e.seed))
+  e.seen = make(map[string]bool)
+  e.proposed = 0
+  e.cursor = 0
+
+  log.Infof("Using random explorer with seed %d", e.seed)
+
+  size := spaceSize(dims)
+  if int64(e.budget) > size {
+    log.Warnf("Budget exceeds the size of the parameter space: %d > %d", e.budget, size)
+    e.budget = int(size)
+  }
+
+  // When the budget covers a large part of the space, sampling with rejection
+  // becomes slow so instead walk a random permutation of the whole space.
+  if size <= int64(2 * e.budget) {
+    e.perm = e.rand.Perm(int(size))
+  }
+
+  return nil
+}
+
+// permutation returns the parameters at the index of the flattened space
+func (e *RandomExplorer) permutation(idx int) []TaskParam {
+  point := make([]int, len(e.dims))
+  for d := len(e.dims) - 1; d >= 0; d-- {
+    point[d] = idx % len(e.dims[d])
+    idx /= len(e.dims[d])
+  }
+
+  return dimensionParams(e.dims, point)
+}
+
+// sample returns a random set of parameters which has not been seen before
+// and satisfies the job's constraints, or nil when none could be found.
+func (e *RandomExplorer) sample() (*Task, error) {
+  if e.perm != nil {
+    for e.cursor < len(e.perm) {
+      params := e.permutation(e.perm[e.cursor])
+      e.cursor++
+
+      // Permutations which only differ in inactive parameters are the same task
+      task := e.job.newTask(params)
+      if e.seen[task.UUID()] {
+        continue
+      }
+
+      ok, err := e.job.feasible(params)
+      if err != nil {
+        return nil, err
+      }
+      if !ok {
+        // Only prune the task once however many permutations decode to it
+        e.seen[task.UUID()] = true
+        continue
+      }
+
+      return task, nil
+    }
+
+    return nil, nil
+  }
+
+  point := make([]int, len(e.dims))
+  for attempt := 0; attempt < randomAttempts; attempt++ {
+    for d, dim := range e.dims {
+      point[d] = e.rand.Intn(len(dim))
+    }
+    params := dimensionParams(e.dims, point)
+
+    task := e.job.newTask(params)
+    if e.seen[task.UUID()] {
+      continue
+    }
+
+    ok, err := e.job.feasible(params)
+    if err != nil {
+      return nil, err
+    }
+    if !ok {
+      e.seen[task.UUID()] = true
+      continue
+    }
+
+    return task, nil
+  }
+
+  return nil, nil
+}
+
+// Next samples up to n new tasks from the parameter space
+func (e *RandomExplorer) Next(n int) ([]*Task, error) {
+  var tasks []*Task
+
+  for e.proposed < e.budget && (n <= 0 || len(tasks) < n) {
+    task, err := e.sample()
+    if err != nil {
+      return tasks, err
+    }
+
+    if task == nil {
+      log.Warnf("Could not sample more tasks which satisfy the constraints")
+      e.budget = e.proposed
+      break
+    }
+
+    e.seen[task.UUID()] = true
+    e.proposed++
+    tasks = append(tasks, task)
+  }
+
+  return tasks, nil
+}
+
+// Report is a no-op since random sampling does not depend on the outcome of
+// tasks
+func (e *RandomExplorer) Report(task *Task) {}
+
+// Done returns whether the budget of tasks has been proposed
+func (e *RandomExplorer) Done() bool {
+  return e.proposed >= e.budget
+}
@@ -0,0 +1,63 @@
+package job
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "testing"
+)
+
+// TestRandomPruned checks tasks which many permutations decode to, because
+// their parameters are inactive, are only pruned once
+func TestRandomPruned(t *testing.T) {
+  j := explorerJob(t, JobExplorer{Type: "random", Budget: 100, Seed: 1})
+  j.Params[1].When = "X < 2"
+  j.Constraints = []string{"X != 7"}
+
+  if err := j.compileConditions(); err != nil {
+    t.Fatal(err)
+  }
+  if err := j.compileConstraints(); err != nil {
+    t.Fatal(err)
+  }
+
+  proposed := explore(t, j, func(task *Task) map[string]float64 {
+    return nil
+  })
+
+  // X=0 and X=1 with each Y, and X from 2 to 6 without Y
+  if len(proposed) != 13 {
+    t.Errorf("Got %d tasks, expected 13", len(proposed))
+  }
+
+  if j.pruned != 1 {
+    t.Errorf("Got %d pruned tasks, expected 1", j.pruned)
+  }
+}
@@ -0,0 +1,422 @@
+package job
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "fmt"
+  "time"
+  "context"
+  "strings"
+
+  "github.com/novln/docker-parser"
+
+  "github.com/lancs-net/wayfinder/log"
+  "github.com/lancs-net/wayfinder/run"
+)
+
+// runResult is the outcome of a task's run once it has finished
+type runResult struct {
+  atr     *ActiveTaskRun
+  elapsed  time.Duration
+  failed   bool
+  timedOut bool
+}
+
+// scheduler launches the runs of the job's tasks on the free cores and is
+// notified over its channel whenever one of them finishes.
+type scheduler struct {
+  job        *Job
+  ctx         context.Context // stops the runs in flight once cancelled
+  done        chan runResult
+  grace       time.Duration // minimum time between consecutive launches
+  lastLaunch  time.Time
+  memory      int64 // bytes of memory which are not allocated to runs
+  inFlight    int
+  launched    int
+  total       int
+  proposed    int // number of tasks last proposed by the explorer
+  order      *orderer
+  depths    []int // of each run in the graph of runs
+}
+
+// Start the job and all of its tasks.  Runs are launched as soon as there are
+// enough free cores for them and no more runs are launched once the context is
+// cancelled.
+func (j *Job) Start(ctx context.Context) error {
+  // Pre-emptively pull all images, except those which depend on the task
+  for _, r := range j.Runs {
+    if strings.Contains(r.Image, "{{") {
+      continue
+    }
+
+    ref, err := dockerparser.Parse(r.Image)
+    if err != nil {
+      return fmt.Errorf("Could not parse image: %s", err)
+    }
+
+    log.Infof("Pulling %s...", ref.Remote())
+
+    _, err = run.PullImage(ref.Remote(), j.bridge.CacheDir)
+    if err != nil {
+      return fmt.Errorf("Could not pull image: %s", err)
+    }
+
+    if ctx.Err() != nil {
+      return ctx.Err()
+    }
+  }
+
+  s := &scheduler{
+    job:   j,
+    ctx:    ctx,
+    done:  make(chan runResult, len(tasksInFlight.All())),
+    grace:  time.Duration(j.scheduleGrace) * time.Second,
+    memory: j.memory,
+    order:  newOrderer(j.Order),
+    depths: runDepths(runDependencies(j.Runs)),
+  }
+
+  for {
+    wait, err := s.fill()
+    if err != nil {
+      return err
+    }
+
+    if s.inFlight == 0 && wait == 0 {
+      if j.waitList.Len() > 0 {
+        return fmt.Errorf("Could not schedule any of the %d waiting tasks", j.waitList.Len())
+      } else if !j.exploring() {
+        break
+      } else if s.proposed == 0 {
+        return fmt.Errorf("Explorer did not propose any tasks although none are in flight")
+      }
+
+      // Reused tasks were reported, so the explorer may now propose more
+      continue
+    }
+
+    // Only wake up for the grace period when a launch was held back by it
+    var graceTimer <-chan time.Time
+    if wait > 0 {
+      graceTimer = time.After(wait)
+    }
+
+    select {
+    case <-ctx.Done():
+      log.Warnf("Stopping job with %d runs in flight", s.inFlight)
+
+      // The runs in flight are stopped by the context, wait for them to be
+      // destroyed and their cores released
+      for s.inFlight > 0 {
+        s.finish(<-s.done)
+      }
+
+      return ctx.Err()
+
+    case res := <-s.done:
+      s.finish(res)
+
+    case <-graceTimer:
+    }
+  }
+
+  j.summarize()
+
+  return nil
+}
+
+// runMemory returns the bytes of memory the run is limited to, or 0 if it does
+// not have a limit
+func runMemory(r run.Run) int64 {
+  memory, err := run.ParseSize(r.Memory)
+  if err != nil {
+    return 0
+  }
+
+  return memory
+}
+
+// formatSize formats bytes using the largest unit they are a multiple of
+func formatSize(size int64) string {
+  units := []string{"", "K", "M", "G", "T"}
+
+  i := 0
+  for ; i < len(units) - 1 && size >= 1024 && size % 1024 == 0; i++ {
+    size /= 1024
+  }
+
+  return fmt.Sprintf("%d%s", size, units[i])
+}
+
+// waitingTasks returns the number of tasks in the wait list which do not have
+// a run in flight
+func (s *scheduler) waitingTasks() int {
+  waiting := 0
+  for i := 0; i < s.job.waitList.Len(); i++ {
+    task, err := s.job.waitList.Get(i)
+    if err == nil && task.(*Task).inFlight() == 0 {
+      waiting++
+    }
+  }
+
+  return waiting
+}
+
+// fill launches the runs of the waiting tasks whose dependencies have
+// succeeded, in the job's order, for as long as there are enough free cores
+// for them.  When a launch is held back by the grace period, it returns how
+// long to wait until the next launch.
+func (s *scheduler) fill() (time.Duration, error) {
+  j := s.job
+  freeCores := tasksInFlight.FreeCores()
+
+  // Ask the explorer for new tasks when there are not enough waiting tasks
+  // to occupy the free cores, such that tasks are only generated on demand,
+  // or to fill the window of the order policy
+  wanted := s.order.lookahead(len(freeCores))
+  waiting := s.waitingTasks()
+  for waiting < wanted && j.exploring() {
+    added, proposed, err := j.explore(wanted - waiting)
+    if err != nil {
+      return 0, err
+    }
+
+    s.proposed = proposed
+    s.total += added * runInstances(j.Runs)
+    waiting += added
+
+    if proposed == 0 {
+      break
+    }
+  }
+
+  var candidates []candidate
+  for i := 0; i < j.waitList.Len(); i++ {
+    item, err := j.waitList.Get(i)
+    if err != nil {
+      log.Errorf("Could not get task from wait list: %s", err)
+      continue
+    }
+
+    task := item.(*Task)
+    for _, ri := range task.ready() {
+      candidates = append(candidates, candidate{task, ri, s.depths[ri.index]})
+    }
+  }
+
+  s.order.sort(candidates)
+
+  for _, c := range candidates {
+    if len(tasksInFlight.FreeCores()) == 0 {
+      break
+    }
+
+    r := c.task.runs[c.ri.index]
+    if s.job.memory > 0 && runMemory(r) > s.memory {
+      continue
+    }
+
+    cores, reserved, nodes := tasksInFlight.Allocate(r.Cores)
+    if cores == nil {
+      continue
+    }
+
+    if s.grace > 0 && !s.lastLaunch.IsZero() {
+      if wait := s.grace - time.Since(s.lastLaunch); wait > 0 {
+        return wait, nil
+      }
+    }
+
+    s.launch(c.task, c.ri, cores, reserved, nodes)
+  }
+
+  // Remove the tasks none of whose runs could be started
+  for i := 0; i < j.waitList.Len(); i++ {
+    if item, err := j.waitList.Get(i); err == nil && item.(*Task).finished() {
+      j.waitList.Remove(i)
+      i--
+    }
+  }
+
+  return 0, nil
+}
+
+// launch starts the task's run on the cores in the background, keeping the
+// reserved cores idle.  If the run could not be initialized, it fails and the
+// runs which depend on it are skipped.
+func (s *scheduler) launch(task *Task, ri runInstance, cores, reserved, nodes []int) {
+  j := s.job
+  r := task.runs[ri.index]
+
+  atr, err := NewActiveTaskRun(task, r, ri.rep, cores, j.bridge, j.dryRun, j.maxRetries)
+  if err != nil {
+    log.Errorf("Could not initialize run for this task: %s", err)
+
+    task.completed(ri, false)
+    if task.finished() {
+      j.report(task)
+    }
+    return
+  }
+
+  atr.ReservedIds = reserved
+  atr.MemNodes = nodes
+  atr.timeout = runTimeout(r, j.timeout)
+  atr.killGrace = j.killGrace
+
+  // Record the task once its first run is scheduled
+  if !task.scheduled() {
+    err = j.recordTask(task)
+    if err != nil {
+      log.Warnf("Could not record task: %s", err)
+    }
+
+    if !j.dryRun {
+      err = task.writeManifest(TaskRunning)
+      if err != nil {
+        log.Warnf("Could not record task: %s", err)
+      }
+    }
+  }
+
+  s.launched++
+  log.Infof("Scheduling task run %s (%d/%d)...",
+    atr.UUID(),
+    s.launched,
+    s.total,
+  )
+
+  task.started(ri)
+
+  // Add the active task to the list of utilised cores
+  for _, coreId := range append(cores, reserved...) {
+    err := tasksInFlight.Set(coreId, atr)
+    if err != nil {
+      log.Warnf("Could not schedule task on core ID %d: %s", coreId, err)
+    }
+  }
+
+  s.memory -= runMemory(r)
+  s.inFlight++
+  s.lastLaunch = time.Now()
+
+  // Oversee the runtime of this task's run in a thread of its own, which
+  // notifies the scheduler once it has finished
+  go func() {
+    s.done <- execute(s.ctx, atr)
+  }()
+}
+
+// execute starts the run, retrying it if it fails, until it finishes.  A run
+// which timed out is not retried as it would likely hang again, and a run
+// which was stopped because the context was cancelled has failed.
+func execute(ctx context.Context, atr *ActiveTaskRun) runResult {
+  for i := 0; i < atr.maxRetries + 1; i++ {
+    returnCode, timeElapsed, err := atr.Start(ctx)
+    if ctx.Err() != nil {
+      log.Warnf("Run %s was stopped", atr.UUID())
+      return runResult{atr: atr, failed: true}
+    } else if err == run.ErrTimeout {
+      log.Errorf("Run %s timed out after %s", atr.UUID(), timeElapsed)
+      return runResult{atr: atr, elapsed: timeElapsed, failed: true, timedOut: true}
+    } else if err != nil {
+      log.Errorf("Could not complete run: %s: %s", atr.UUID(), err)
+    } else if returnCode != 0 {
+      log.Errorf(
+        "Could not complete run: %s: exited with return code %d",
+        atr.UUID(),
+        returnCode,
+      )
+    }
+
+    if timeElapsed > 0 {
+      log.Successf("Run %s finished in %s", atr.UUID(), timeElapsed)
+      return runResult{atr: atr, elapsed: timeElapsed}
+    }
+
+    log.Errorf("Run %s finished with errors", atr.UUID())
+    if i < atr.maxRetries {
+      log.Infof("Trying run again (%d/%d)", i + 1, atr.maxRetries)
+    }
+  }
+
+  return runResult{atr: atr, failed: true}
+}
+
+// outcome describes how the run finished
+func (res runResult) outcome() string {
+  if res.timedOut {
+    return OutcomeTimeout
+  } else if res.failed {
+    return OutcomeFailed
+  }
+
+  return OutcomeSucceeded
+}
+
+// finish releases the cores of the run and reports the task to the explorer
+// once it has no more runs
+func (s *scheduler) finish(res runResult) {
+  j := s.job
+  task := res.atr.Task
+
+  for _, coreId := range append(res.atr.CoreIds, res.atr.ReservedIds...) {
+    tasksInFlight.Unset(coreId)
+  }
+
+  s.memory += runMemory(*res.atr.run)
+  s.inFlight--
+
+  // A failed run skips the runs which depend on it, but not the others
+  task.completed(res.atr.instance, !res.failed)
+  task.recordOutcome(res.atr.instance, res.outcome())
+  if !res.failed {
+    task.recordDuration(res.atr.instance, res.elapsed)
+    if !j.dryRun {
+      s.total -= j.repeatUntil(task, res.atr.instance)
+    }
+  }
+
+  if !task.finished() {
+    return
+  }
+
+  // Remove the task from the wait list once it has no more runs
+  for i := 0; i < j.waitList.Len(); i++ {
+    if item, err := j.waitList.Get(i); err == nil && item.(*Task) == task {
+      j.waitList.Remove(i)
+      break
+    }
+  }
+
+  // Let the explorer know once the task has no more runs
+  j.report(task)
+}
@@ -36,6 +36,7 @@ import (
   "fmt"
   "time"
   "path"
+  "context"
   "strings"
 	"crypto/md5"
 
@@ -44,9 +45,10 @@ import (
 )
 
 type TaskParam struct {
-  Name  string
-  Type  string
-  Value string
+  Name     string
+  Type     string
+  Value    string
+  Inactive bool // fixed to its default because its condition is not met
 }
 
 // Task is the specific iterated configuration
@@ -54,17 +56,29 @@ type Task struct {
   Params      []TaskParam
   Inputs     *[]run.Input
   Outputs    *[]run.Output
-  runs         *Queue
+  Metrics       map[string]float64
+  runs        []run.Run
+  deps      [][]int // indices of the runs each run depends on
+  states    [][]int // of each repetition of each run
+  dag           bool
   uuid          string
+  manifest     *TaskManifest
   resultsDir    string
   cacheDir      string
+  cancelled     bool
   AllowOverride bool
 }
 
 // Init prepare the task 
 func (t *Task) Init(workDir string, allowOverride bool, runs *[]run.Run, dryRun bool) error {
-  // Create a queue of runs for this particular task
-  t.runs = NewQueue(len(*runs))
+  // Keep track of the state of each of the runs of this particular task
+  t.runs = *runs
+  t.deps = runDependencies(*runs)
+  t.states = make([][]int, len(*runs))
+  for i, r := range *runs {
+    t.states[i] = make([]int, repeats(r))
+  }
+  t.dag = isDag(*runs)
 
   // Set the working directory
   t.resultsDir = path.Join(workDir, "results", t.UUID())
@@ -73,6 +87,30 @@ func (t *Task) Init(workDir string, allowOverride bool, runs *[]run.Run, dryRun
   // Set additional task configuration
   t.AllowOverride = allowOverride
 
+  // Results of a previous job are only reused if they were produced by the
+  // same definition of the task
+  if t.manifest != nil && !allowOverride {
+    previous, err := readManifest(t.resultsDir)
+    if err != nil {
+      return err
+    }
+
+    // Images are not looked up in a dry run, so they are assumed unchanged
+    if previous != nil && t.manifest.assumeDigests(previous) {
+      t.manifest.Hash, err = t.manifest.hash()
+      if err != nil {
+        return err
+      }
+    }
+
+    if previous != nil && previous.Hash != t.manifest.Hash {
+      return fmt.Errorf("Results of task %s were produced by a different definition of the task: %s", t.UUID(), t.resultsDir)
+    } else if previous != nil && previous.Status == TaskComplete {
+      t.manifest.Until = previous.Until
+      return errTaskComplete
+    }
+  }
+
   // Create a results directory for this task
   if _, err := os.Stat(t.resultsDir); os.IsNotExist(err) {
     if !dryRun {
@@ -94,28 +132,45 @@ func (t *Task) Init(workDir string, allowOverride bool, runs *[]run.Run, dryRun
     }
   }
 
-  // Add the runs in-order
-  for _, run := range *runs {
-    t.runs.Enqueue(run)
+  return nil
+}
+
+// paramsMap returns the values of the task's parameters by name
+func (t *Task) paramsMap() map[string]string {
+  params := make(map[string]string, len(t.Params))
+  for _, param := range t.Params {
+    params[param.Name] = param.Value
   }
 
-  return nil
+  return params
 }
 
-// Cancel the task by removing everything from the queue
+// Cancel the task by skipping all of the runs which have not been started
 func (t *Task) Cancel() {
   log.Warnf("Cancelling task and all subsequent runs")
 
-  // Clear queue of subsequent runs
-  t.runs.Clear()
+  t.cancelled = true
+
+  for i := range t.states {
+    for rep, state := range t.states[i] {
+      if state == runPending {
+        t.states[i][rep] = runSkipped
+      }
+    }
+  }
 }
 
+// UUID returns the ID of the task, which is derived from its parameters only
 func (t *Task) UUID() string {
   if len(t.uuid) == 0 {
 
     // Calculate the UUID based on a reproducible md5 seed
     md5val := md5.New()
     for _, param := range t.Params {
+      // Inactive parameters have no effect on the task and are not part of it
+      if param.Inactive {
+        continue
+      }
       io.WriteString(md5val, fmt.Sprintf("%s=%s\n", param.Name, param.Value))
     }
 
@@ -125,12 +180,27 @@ func (t *Task) UUID() string {
   return t.uuid
 }
 
+// Hash returns the hash of the task's definition, including its runs, their
+// images and its inputs
+func (t *Task) Hash() string {
+  if t.manifest == nil {
+    return ""
+  }
+
+  return t.manifest.Hash
+}
+
 // ActiveTaskRun contains information about a particular task's run.
 type ActiveTaskRun struct {
   Task       *Task
   Runner     *run.Runner
   run        *run.Run
+  instance    runInstance
   CoreIds   []int // the exact core numbers this task is using
+  ReservedIds []int // siblings of the cores which are kept idle
+  MemNodes  []int // the NUMA nodes of the cores
+  timeout     time.Duration
+  killGrace   time.Duration
   log        *log.Logger
   workDir     string
   dryRun      bool
@@ -138,12 +208,13 @@ type ActiveTaskRun struct {
   maxRetries  int
 }
 
-// NewActiveTaskRun initializes the current task and the run step for the
-// the specified cores.
-func NewActiveTaskRun(task *Task, run run.Run, coreIds []int, bridge *run.Bridge, dryRun bool, maxRetries int) (*ActiveTaskRun, error) {
+// NewActiveTaskRun initializes the current task and the repetition of the run
+// step for the the specified cores.
+func NewActiveTaskRun(task *Task, run run.Run, rep int, coreIds []int, bridge *run.Bridge, dryRun bool, maxRetries int) (*ActiveTaskRun, error) {
   atr := &ActiveTaskRun{
     Task:       task,
     run:       &run,
+    instance:   runInstance{task.runIndex(run.Name), rep},
     CoreIds:    coreIds,
     maxRetries: maxRetries,
   }
@@ -160,18 +231,27 @@ func NewActiveTaskRun(task *Task, run run.Run, coreIds []int, bridge *run.Bridge
 
 // UUID returns the Unique ID for the task and run
 func (atr *ActiveTaskRun) UUID() string {
+  if repeats(*atr.run) > 1 {
+    return fmt.Sprintf("%s-%s-%d", atr.Task.UUID(), atr.run.Name, atr.instance.rep)
+  }
+
   return fmt.Sprintf("%s-%s", atr.Task.UUID(), atr.run.Name)
 }
 
-// Start the task's run
-func (atr *ActiveTaskRun) Start() (int, time.Duration, error) {
+// Start the task's run, which is stopped once the context is cancelled
+func (atr *ActiveTaskRun) Start(ctx context.Context) (int, time.Duration, error) {
   var env []string
   var err error
 
+  if ctx.Err() != nil {
+    return 1, -1, ctx.Err()
+  }
+
   for _, param := range atr.Task.Params {
     env = append(env, fmt.Sprintf("%s=%s", param.Name, param.Value))
   }
 
+  env = append(env, fmt.Sprintf("WAYFINDER_REPETITION=%d", atr.instance.rep))
   env = append(env, fmt.Sprintf("WAYFINDER_TOTAL_CORES=%d", len(atr.CoreIds)))
   env = append(env, fmt.Sprintf("WAYFINDER_CORES=%s", strings.Trim(
     strings.Join(strings.Fields(fmt.Sprint(atr.CoreIds)), " "), "[]",
@@ -180,24 +260,49 @@ func (atr *ActiveTaskRun) Start() (int, time.Duration, error) {
     env = append(env, fmt.Sprintf("WAYFINDER_CORE_ID%d=%d", i, coreId))
   }
 
+  // Evaluate the templates of the run and its inputs for this task
+  r, err := atr.Task.renderRun(*atr.run)
+  if err != nil {
+    return 1, -1, err
+  }
+
+  inputs, err := atr.Task.renderInputs()
+  if err != nil {
+    return 1, -1, err
+  }
+
+  limits, err := resourceLimits(atr.run)
+  if err != nil {
+    return 1, -1, err
+  }
+
   config := &run.RunnerConfig{
     Log:           atr.log,
     CacheDir:      atr.Task.cacheDir,
     ResultsDir:    atr.Task.resultsDir,
+    OutputsDir:    atr.Task.outputsDir(atr.instance),
+    OutputsFrom:   atr.Task.outputsFrom(atr.instance.index),
     AllowOverride: atr.Task.AllowOverride,
     Name:          atr.run.Name,
-    Image:         atr.run.Image,
+    Image:         r.Image,
     CoreIds:       atr.CoreIds,
+    MemNodes:      atr.MemNodes,
+    Memory:        limits.Memory,
+    MemorySwap:    limits.MemorySwap,
+    Hugepages:     limits.Hugepages,
+    PidsLimit:     atr.run.PidsLimit,
+    Timeout:       atr.timeout,
+    KillGrace:     atr.killGrace,
     Devices:       atr.run.Devices,
-    Inputs:        atr.Task.Inputs,
+    Inputs:        inputs,
     Outputs:       atr.Task.Outputs,
     Env:           env,
     Capabilities:  atr.run.Capabilities,
   }
-  if atr.run.Path != "" {
-    config.Path = atr.run.Path
-  } else if atr.run.Cmd != "" {
-    config.Cmd = atr.run.Cmd
+  if r.Path != "" {
+    config.Path = r.Path
+  } else if r.Cmd != "" {
+    config.Cmd = r.Cmd
   } else {
     return 1, -1, fmt.Errorf("Run did not specify path or cmd: %s", atr.run.Name)
   }
@@ -208,15 +313,61 @@ func (atr *ActiveTaskRun) Start() (int, time.Duration, error) {
   }
 
   atr.log.Infof("Starting run...")
-  exitCode, timeElapsed, err := atr.Runner.Run()
+  exitCode, timeElapsed, err := atr.Runner.Run(ctx)
   atr.Runner.Destroy()
-  if err != nil {
+  if err == run.ErrTimeout {
+    return exitCode, timeElapsed, err
+  } else if err != nil {
     return 1, -1, fmt.Errorf("Could not start runner: %s", err)
   }
 
   return exitCode, timeElapsed, nil
 }
 
+// runTimeout returns how long the run may take before it is stopped, which is
+// the default unless the run sets its own
+func runTimeout(r run.Run, timeout time.Duration) time.Duration {
+  if t, err := time.ParseDuration(r.Timeout); err == nil {
+    return t
+  }
+
+  return timeout
+}
+
+// resourceLimits converts the limits on the resources of the run to bytes
+func resourceLimits(r *run.Run) (*run.RunnerConfig, error) {
+  var err error
+  limits := &run.RunnerConfig{}
+
+  if len(r.Memory) > 0 {
+    limits.Memory, err = run.ParseSize(r.Memory)
+    if err != nil {
+      return nil, err
+    }
+  }
+
+  if len(r.MemorySwap) > 0 {
+    limits.MemorySwap, err = run.ParseSwap(r.MemorySwap)
+    if err != nil {
+      return nil, err
+    }
+  }
+
+  if len(r.Hugepages) > 0 {
+    limits.Hugepages = make(map[string]uint64)
+  }
+  for pagesize, limit := range r.Hugepages {
+    size, err := run.ParseSize(limit)
+    if err != nil {
+      return nil, err
+    }
+
+    limits.Hugepages[pagesize] = uint64(size)
+  }
+
+  return limits, nil
+}
+
 // IsDirEmpty is a method used to determine whether a directory is empty
 func IsDirEmpty(path string) (bool, error) {
   f, err := os.Open(path)
@@ -0,0 +1,204 @@
+package job
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "os"
+  "fmt"
+  "path"
+  "bytes"
+  "strings"
+  "io/ioutil"
+  "text/template"
+
+  "github.com/lancs-net/wayfinder/run"
+)
+
+// parseTemplate parses text in which the task's parameters can be referred to
+// as {{ .NAME }}.  Parameters which are not set are empty.
+func parseTemplate(name string, text string) (*template.Template, error) {
+  tmpl, err := template.New(name).Option("missingkey=zero").Parse(text)
+  if err != nil {
+    return nil, fmt.Errorf("Invalid template: %s", err)
+  }
+
+  return tmpl, nil
+}
+
+// render evaluates the template with the task's parameters
+func (t *Task) render(name string, text string) (string, error) {
+  // Avoid parsing text which cannot contain any actions
+  if !strings.Contains(text, "{{") {
+    return text, nil
+  }
+
+  tmpl, err := parseTemplate(name, text)
+  if err != nil {
+    return "", err
+  }
+
+  var buf bytes.Buffer
+  err = tmpl.Execute(&buf, t.paramsMap())
+  if err != nil {
+    return "", fmt.Errorf("Could not render %s: %s", name, err)
+  }
+
+  return buf.String(), nil
+}
+
+// renderRun returns a copy of the run with its image, cmd and path rendered
+// for the task.
+func (t *Task) renderRun(r run.Run) (run.Run, error) {
+  var err error
+
+  r.Image, err = t.render("image", r.Image)
+  if err != nil {
+    return r, err
+  }
+
+  r.Cmd, err = t.render("cmd", r.Cmd)
+  if err != nil {
+    return r, err
+  }
+
+  r.Path, err = t.render("path", r.Path)
+  if err != nil {
+    return r, err
+  }
+
+  return r, nil
+}
+
+// renderInput returns a copy of the input with its source and destination
+// rendered for the task.  For inputs with inline content, or whose source is a
+// template, the rendered content which replaces the source is also returned.
+func (t *Task) renderInput(input run.Input) (run.Input, *string, error) {
+  var err error
+
+  input.Destination, err = t.render("destination", input.Destination)
+  if err != nil {
+    return input, nil, err
+  }
+
+  input.Source, err = t.render("source", input.Source)
+  if err != nil {
+    return input, nil, err
+  }
+
+  var content string
+  if len(input.Content) > 0 {
+    content, err = t.render("content", input.Content)
+    if err != nil {
+      return input, nil, err
+    }
+  } else if input.Template {
+    dat, err := ioutil.ReadFile(input.Source)
+    if err != nil {
+      return input, nil, fmt.Errorf("Could not read input template: %s", err)
+    }
+
+    content, err = t.render(input.Source, string(dat))
+    if err != nil {
+      return input, nil, err
+    }
+  } else {
+    return input, nil, nil
+  }
+
+  return input, &content, nil
+}
+
+// renderInputs returns a copy of the task's inputs rendered for the task.
+// Inputs with inline content, or whose source is a template, are rendered into
+// a file in the cache which replaces their source.
+func (t *Task) renderInputs() (*[]run.Input, error) {
+  var inputs []run.Input
+
+  for i, input := range *t.Inputs {
+    input, content, err := t.renderInput(input)
+    if err != nil {
+      return nil, err
+    }
+
+    if content == nil {
+      inputs = append(inputs, input)
+      continue
+    }
+
+    // Keep the permissions of templates so that scripts remain executable
+    mode := os.FileMode(0644)
+    if info, err := os.Stat(input.Source); err == nil && len(input.Content) == 0 {
+      mode = info.Mode()
+    }
+
+    source := path.Join(t.cacheDir, "inputs", t.UUID(), fmt.Sprintf("%d", i))
+    err = os.MkdirAll(path.Dir(source), os.ModePerm)
+    if err != nil {
+      return nil, fmt.Errorf("Could not create input directory: %s", err)
+    }
+
+    err = ioutil.WriteFile(source, []byte(*content), mode)
+    if err != nil {
+      return nil, fmt.Errorf("Could not write input: %s", err)
+    }
+
+    input.Source = source
+    inputs = append(inputs, input)
+  }
+
+  return &inputs, nil
+}
+
+// checkTemplates parses each of the job's templates and returns the problems
+// with them.
+func (j *Job) checkTemplates() []error {
+  var errs []error
+
+  check := func(what string, text string) {
+    if _, err := parseTemplate(what, text); err != nil {
+      errs = append(errs, err)
+    }
+  }
+
+  for _, r := range j.Runs {
+    check(fmt.Sprintf("Image of run %s", r.Name), r.Image)
+    check(fmt.Sprintf("Cmd of run %s", r.Name), r.Cmd)
+    check(fmt.Sprintf("Path of run %s", r.Name), r.Path)
+  }
+
+  for _, input := range j.Inputs {
+    check(fmt.Sprintf("Source of input %s", input.Source), input.Source)
+    check(fmt.Sprintf("Destination of input %s", input.Source), input.Destination)
+    check(fmt.Sprintf("Content of input %s", input.Destination), input.Content)
+  }
+
+  return errs
+}
@@ -0,0 +1,408 @@
+package job
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "fmt"
+  "sort"
+  "path"
+  "strconv"
+  "strings"
+  "io/ioutil"
+  "path/filepath"
+
+  "github.com/lancs-net/wayfinder/log"
+)
+
+// Cpu describes where a logical CPU is located
+type Cpu struct {
+  Id         int
+  Core       int   // ID of the physical core within its package
+  Package    int
+  Node       int   // NUMA node, -1 if unknown
+  Siblings []int   // SMT siblings sharing the physical core, excluding itself
+}
+
+// Topology describes the CPUs which runs are allocated on
+type Topology struct {
+  cpus   map[int]*Cpu
+  nodes  []int // NUMA nodes in order
+}
+
+// readSysfsInt reads a file in sysfs holding a single integer
+func readSysfsInt(filePath string) (int, error) {
+  dat, err := ioutil.ReadFile(filePath)
+  if err != nil {
+    return 0, err
+  }
+
+  return strconv.Atoi(strings.TrimSpace(string(dat)))
+}
+
+// parseCpuList parses a list of CPUs as used by sysfs and cpusets, e.g.
+// 0-3,8,10-11
+func parseCpuList(list string) ([]int, error) {
+  var cpus []int
+
+  list = strings.TrimSpace(list)
+  if len(list) == 0 {
+    return cpus, nil
+  }
+
+  for _, part := range strings.Split(list, ",") {
+    bounds := strings.SplitN(part, "-", 2)
+    start, err := strconv.Atoi(bounds[0])
+    if err != nil {
+      return nil, fmt.Errorf("Invalid CPU list: %s", list)
+    }
+
+    end := start
+    if len(bounds) == 2 {
+      end, err = strconv.Atoi(bounds[1])
+      if err != nil || end < start {
+        return nil, fmt.Errorf("Invalid CPU list: %s", list)
+      }
+    }
+
+    for c := start; c <= end; c++ {
+      cpus = append(cpus, c)
+    }
+  }
+
+  return cpus, nil
+}
+
+// ReadTopology determines the physical core, package and NUMA node of each of
+// the CPUs from sysfs, which is mounted at sysfsRoot.  CPUs whose topology is
+// not exposed are treated as separate cores on a single node, and CPUs which
+// no NUMA node lists are placed on a node of their own.
+func ReadTopology(sysfsRoot string, cpus []int) (*Topology, error) {
+  t := &Topology{
+    cpus: make(map[int]*Cpu, len(cpus)),
+  }
+
+  var unknown []int
+  cpuDir := path.Join(sysfsRoot, "devices", "system", "cpu")
+  nodeDir := path.Join(sysfsRoot, "devices", "system", "node")
+
+  for _, id := range cpus {
+    cpu := &Cpu{
+      Id:      id,
+      Core:    id,
+      Package: 0,
+      Node:    -1,
+    }
+    t.cpus[id] = cpu
+
+    topology := path.Join(cpuDir, fmt.Sprintf("cpu%d", id), "topology")
+
+    core, err := readSysfsInt(path.Join(topology, "core_id"))
+    if err != nil {
+      log.Debugf("Could not read topology of CPU %d: %s", id, err)
+      unknown = append(unknown, id)
+      continue
+    }
+    cpu.Core = core
+
+    pkg, err := readSysfsInt(path.Join(topology, "physical_package_id"))
+    if err == nil {
+      cpu.Package = pkg
+    }
+
+    dat, err := ioutil.ReadFile(path.Join(topology, "thread_siblings_list"))
+    if err == nil {
+      siblings, err := parseCpuList(string(dat))
+      if err != nil {
+        return nil, err
+      }
+
+      for _, sibling := range siblings {
+        if sibling != id {
+          cpu.Siblings = append(cpu.Siblings, sibling)
+        }
+      }
+    }
+  }
+
+  if len(unknown) > 0 {
+    log.Warnf("Could not read topology of %d CPUs in %s", len(unknown), cpuDir)
+  }
+
+  // Assign each CPU to the NUMA node which lists it
+  nodes, err := filepath.Glob(path.Join(nodeDir, "node[0-9]*"))
+  if err != nil {
+    return nil, fmt.Errorf("Could not find NUMA nodes: %s", err)
+  }
+
+  for _, dir := range nodes {
+    node, err := strconv.Atoi(strings.TrimPrefix(path.Base(dir), "node"))
+    if err != nil {
+      continue
+    }
+
+    dat, err := ioutil.ReadFile(path.Join(dir, "cpulist"))
+    if err != nil {
+      log.Warnf("Could not read CPUs of NUMA node %d: %s", node, err)
+      continue
+    }
+
+    list, err := parseCpuList(string(dat))
+    if err != nil {
+      return nil, err
+    }
+
+    used := false
+    for _, id := range list {
+      if cpu, ok := t.cpus[id]; ok {
+        cpu.Node = node
+        used = true
+      }
+    }
+
+    if used {
+      t.nodes = append(t.nodes, node)
+    }
+  }
+
+  // Without NUMA information all CPUs are considered to be on the same node,
+  // as are the CPUs which no NUMA node lists, so that they are still used
+  unlisted := 0
+  for _, cpu := range t.cpus {
+    if cpu.Node < 0 {
+      unlisted++
+    }
+  }
+
+  if len(t.nodes) > 0 && unlisted > 0 {
+    log.Warnf("%d CPUs are not listed by any NUMA node in %s", unlisted, nodeDir)
+  }
+
+  if len(t.nodes) == 0 || unlisted > 0 {
+    t.nodes = append(t.nodes, -1)
+  }
+
+  sort.Ints(t.nodes)
+
+  return t, nil
+}
+
+// Cpu returns the topology of the CPU
+func (t *Topology) Cpu(id int) *Cpu {
+  return t.cpus[id]
+}
+
+// capacity returns the number of CPUs a single run can be allocated
+func (t *Topology) capacity(reserveSiblings bool) int {
+  size := 0
+  for _, node := range t.nodes {
+    size += t.nodeSize(node, reserveSiblings)
+  }
+
+  return size
+}
+
+// allocate selects n of the free CPUs for a run.  The CPUs are all on the
+// same NUMA node where possible and are as contiguous as possible, avoiding
+// CPUs whose siblings are busy and preferring the node on which the fewest
+// free CPUs remain so that larger runs can still be placed elsewhere.
+//
+// When reserveSiblings is set, only one CPU of each physical core is selected
+// and its siblings are returned to be kept idle.  The NUMA nodes of the
+// selected CPUs are returned last.  It returns nil if the CPUs are not free at
+// the moment.
+func (t *Topology) allocate(free []int, n int, reserveSiblings bool) ([]int, []int, []int) {
+  isFree := make(map[int]bool, len(free))
+  for _, id := range free {
+    isFree[id] = true
+  }
+
+  // Determine which CPUs can be selected on each node
+  eligible := make(map[int][]int)
+  for _, id := range free {
+    cpu := t.cpus[id]
+    if cpu == nil {
+      continue
+    }
+
+    if reserveSiblings {
+      ok := true
+      for _, sibling := range cpu.Siblings {
+        // Only use the first of the siblings and only when all are idle
+        if _, managed := t.cpus[sibling]; managed && (!isFree[sibling] || sibling < id) {
+          ok = false
+        }
+      }
+      if !ok {
+        continue
+      }
+    }
+
+    eligible[cpu.Node] = append(eligible[cpu.Node], id)
+  }
+
+  // busy returns the number of the CPUs which share a core with another run
+  busy := func(ids []int) int {
+    count := 0
+    for _, id := range ids {
+      for _, sibling := range t.cpus[id].Siblings {
+        if _, managed := t.cpus[sibling]; managed && !isFree[sibling] {
+          count++
+          break
+        }
+      }
+    }
+    return count
+  }
+
+  var best []int
+  bestBusy := 0
+  bestSpan := 0
+  bestLeft := 0
+  for _, node := range t.nodes {
+    ids := eligible[node]
+    if len(ids) < n {
+      continue
+    }
+
+    sort.Ints(ids)
+
+    // Find the window of n CPUs sharing the fewest cores with other runs and
+    // with the smallest span
+    for i := 0; i + n <= len(ids); i++ {
+      window := ids[i:i + n]
+      shared := busy(window)
+      span := window[n - 1] - window[0]
+      left := len(ids) - n
+      if best == nil || shared < bestBusy ||
+          (shared == bestBusy && span < bestSpan) ||
+          (shared == bestBusy && span == bestSpan && left < bestLeft) {
+        best = window
+        bestBusy = shared
+        bestSpan = span
+        bestLeft = left
+      }
+    }
+  }
+
+  // Spread the run across nodes only if it does not fit on any of them
+  if best == nil {
+    var all []int
+    for _, node := range t.nodes {
+      if t.nodeSize(node, reserveSiblings) >= n {
+        return nil, nil, nil
+      }
+      all = append(all, eligible[node]...)
+    }
+
+    if len(all) < n {
+      return nil, nil, nil
+    }
+
+    sort.Ints(all)
+    best = all[:n]
+  }
+
+  cpus := append([]int{}, best...)
+
+  var reserved []int
+  if reserveSiblings {
+    for _, id := range cpus {
+      for _, sibling := range t.cpus[id].Siblings {
+        if _, managed := t.cpus[sibling]; managed {
+          reserved = append(reserved, sibling)
+        }
+      }
+    }
+  }
+
+  var nodes []int
+  for _, node := range t.nodes {
+    for _, id := range cpus {
+      if t.cpus[id].Node == node && node >= 0 {
+        nodes = append(nodes, node)
+        break
+      }
+    }
+  }
+
+  return cpus, reserved, nodes
+}
+
+// nodeSize returns the number of CPUs of the node which a run can use
+func (t *Topology) nodeSize(node int, reserveSiblings bool) int {
+  size := 0
+  for _, cpu := range t.cpus {
+    if cpu.Node != node {
+      continue
+    }
+
+    if reserveSiblings {
+      first := true
+      for _, sibling := range cpu.Siblings {
+        if _, managed := t.cpus[sibling]; managed && sibling < cpu.Id {
+          first = false
+        }
+      }
+      if !first {
+        continue
+      }
+    }
+
+    size++
+  }
+
+  return size
+}
+
+// concurrency returns the number of runs of n CPUs which can be allocated at
+// once on the CPUs, placing them the way allocate does
+func (t *Topology) concurrency(cpus []int, n int, reserveSiblings bool) int {
+  busy := make(map[int]bool)
+  count := 0
+  for {
+    var free []int
+    for _, id := range cpus {
+      if !busy[id] {
+        free = append(free, id)
+      }
+    }
+
+    allocated, reserved, _ := t.allocate(free, n, reserveSiblings)
+    if allocated == nil {
+      return count
+    }
+
+    for _, id := range append(allocated, reserved...) {
+      busy[id] = true
+    }
+    count++
+  }
+}
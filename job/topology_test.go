@@ -0,0 +1,216 @@
+package job
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "os"
+  "fmt"
+  "path"
+  "reflect"
+  "testing"
+  "io/ioutil"
+)
+
+// fakeCpu is the topology of a CPU as exposed in sysfs
+type fakeCpu struct {
+  id       int
+  core     int
+  siblings string
+}
+
+// fakeSysfs builds a sysfs tree with the CPUs and the cpulist of each NUMA
+// node, where a node without a cpulist is given an empty string
+func fakeSysfs(t *testing.T, cpus []fakeCpu, nodes map[int]string) string {
+  root, err := ioutil.TempDir("", "wayfinder-sysfs")
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  write := func(filePath, val string) {
+    if err := os.MkdirAll(path.Dir(filePath), os.ModePerm); err != nil {
+      t.Fatal(err)
+    }
+    if err := ioutil.WriteFile(filePath, []byte(val + "\n"), 0644); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  for _, cpu := range cpus {
+    dir := path.Join(root, "devices", "system", "cpu", fmt.Sprintf("cpu%d", cpu.id), "topology")
+    write(path.Join(dir, "core_id"), fmt.Sprintf("%d", cpu.core))
+    write(path.Join(dir, "physical_package_id"), "0")
+    write(path.Join(dir, "thread_siblings_list"), cpu.siblings)
+  }
+
+  for node, list := range nodes {
+    dir := path.Join(root, "devices", "system", "node", fmt.Sprintf("node%d", node))
+    if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+      t.Fatal(err)
+    }
+    if len(list) > 0 {
+      write(path.Join(dir, "cpulist"), list)
+    }
+  }
+
+  return root
+}
+
+// twoNodes is a topology of two NUMA nodes with four CPUs each, where CPUs 0
+// and 2, 1 and 3, 4 and 6 and 5 and 7 are SMT siblings
+func twoNodes(t *testing.T) *Topology {
+  root := fakeSysfs(t, []fakeCpu{
+    {0, 0, "0,2"}, {1, 1, "1,3"}, {2, 0, "0,2"}, {3, 1, "1,3"},
+    {4, 2, "4,6"}, {5, 3, "5,7"}, {6, 2, "4,6"}, {7, 3, "5,7"},
+  }, map[int]string{0: "0-3", 1: "4-7"})
+  defer os.RemoveAll(root)
+
+  topology, err := ReadTopology(root, []int{0, 1, 2, 3, 4, 5, 6, 7})
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  return topology
+}
+
+// TestReadTopology checks the cores, siblings and nodes read from sysfs
+func TestReadTopology(t *testing.T) {
+  topology := twoNodes(t)
+
+  if !reflect.DeepEqual(topology.nodes, []int{0, 1}) {
+    t.Errorf("Got nodes %v, expected [0 1]", topology.nodes)
+  }
+
+  cpu := topology.Cpu(6)
+  if cpu.Core != 2 || cpu.Node != 1 || !reflect.DeepEqual(cpu.Siblings, []int{4}) {
+    t.Errorf("Got CPU %+v, expected core 2 on node 1 with sibling 4", *cpu)
+  }
+
+  if c := topology.capacity(false); c != 8 {
+    t.Errorf("Got capacity %d, expected 8", c)
+  }
+
+  if c := topology.capacity(true); c != 4 {
+    t.Errorf("Got capacity %d with reserved siblings, expected 4", c)
+  }
+}
+
+// TestConcurrency checks how many runs fit at once when they are placed on a
+// single NUMA node and siblings are kept idle
+func TestConcurrency(t *testing.T) {
+  topology := twoNodes(t)
+  cpus := []int{0, 1, 2, 3, 4, 5, 6, 7}
+
+  tests := []struct {
+    n               int
+    reserveSiblings bool
+    expected        int
+  }{
+    {1, false, 8},
+    {3, false, 2},
+    {1, true,  4},
+    {2, true,  2},
+    {3, true,  1},
+    {4, true,  1},
+  }
+
+  for _, test := range tests {
+    if c := topology.concurrency(cpus, test.n, test.reserveSiblings); c != test.expected {
+      t.Errorf("Got %d runs of %d CPUs with reserved siblings %v, expected %d", c, test.n, test.reserveSiblings, test.expected)
+    }
+  }
+}
+
+// TestAllocate checks which CPUs are selected for a run
+func TestAllocate(t *testing.T) {
+  topology := twoNodes(t)
+  all := []int{0, 1, 2, 3, 4, 5, 6, 7}
+
+  tests := []struct {
+    name            string
+    free          []int
+    n               int
+    reserveSiblings bool
+    cpus          []int
+    reserved      []int
+    nodes         []int
+  }{
+    {"contiguous on one node", all, 2, false, []int{0, 1}, nil, []int{0}},
+    {"reserved siblings", all, 2, true, []int{0, 1}, []int{2, 3}, []int{0}},
+    {"idle siblings first", []int{0, 1, 4, 5, 6, 7}, 2, false, []int{4, 5}, nil, []int{1}},
+    {"fewest free left", []int{0, 1, 2, 3, 4, 5, 6}, 1, false, []int{4}, nil, []int{1}},
+    {"enough free on a node", []int{0, 1, 3, 4, 5, 6, 7}, 4, false, []int{4, 5, 6, 7}, nil, []int{1}},
+    {"busy siblings", []int{0, 1, 3, 4, 5, 7}, 2, true, nil, nil, nil},
+    {"spread across nodes", all, 6, false, []int{0, 1, 2, 3, 4, 5}, nil, []int{0, 1}},
+    {"fits on a busy node", []int{0, 1, 4, 5, 6, 7}, 3, false, []int{4, 5, 6}, nil, []int{1}},
+    {"does not spread when a node fits", []int{0, 1, 4, 5}, 3, false, nil, nil, nil},
+    {"too many", all, 9, false, nil, nil, nil},
+  }
+
+  for _, test := range tests {
+    cpus, reserved, nodes := topology.allocate(test.free, test.n, test.reserveSiblings)
+    if !reflect.DeepEqual(cpus, test.cpus) ||
+        !reflect.DeepEqual(reserved, test.reserved) ||
+        !reflect.DeepEqual(nodes, test.nodes) {
+      t.Errorf("%s: got %v %v %v, expected %v %v %v",
+        test.name,
+        cpus, reserved, nodes,
+        test.cpus, test.reserved, test.nodes,
+      )
+    }
+  }
+}
+
+// TestUnlistedCpus checks that CPUs which no NUMA node lists, e.g. as a node
+// has no cpulist, are still allocated
+func TestUnlistedCpus(t *testing.T) {
+  root := fakeSysfs(t, []fakeCpu{
+    {0, 0, "0"}, {1, 1, "1"}, {2, 2, "2"},
+  }, map[int]string{0: "0-1", 1: ""})
+  defer os.RemoveAll(root)
+
+  topology, err := ReadTopology(root, []int{0, 1, 2})
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  if !reflect.DeepEqual(topology.nodes, []int{-1, 0}) {
+    t.Errorf("Got nodes %v, expected [-1 0]", topology.nodes)
+  }
+
+  if c := topology.capacity(false); c != 3 {
+    t.Errorf("Got capacity %d, expected 3", c)
+  }
+
+  cpus, _, nodes := topology.allocate([]int{2}, 1, false)
+  if !reflect.DeepEqual(cpus, []int{2}) || nodes != nil {
+    t.Errorf("Got %v on nodes %v, expected [2] on no node", cpus, nodes)
+  }
+}
@@ -0,0 +1,308 @@
+package job
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "fmt"
+  "math"
+
+  "github.com/lancs-net/wayfinder/log"
+)
+
+const (
+  UntilConverged = "converged"
+  UntilMax       = "max"
+)
+
+// errNotRepeated is returned when no run was repeated until the metric
+var errNotRepeated = fmt.Errorf("Metric was not measured over repetitions")
+
+// repeatUntil records the metric of the repetition of the run when the run is
+// repeated until its confidence interval is narrow enough.  Once it is, the
+// remaining repetitions are dropped and their number is returned.
+func (j *Job) repeatUntil(task *Task, ri runInstance) int {
+  r := task.runs[ri.index]
+  if r.Until == nil || task.manifest == nil {
+    return 0
+  }
+
+  u := r.Until
+  objective := j.objective(u.Metric)
+  if objective == nil {
+    return 0
+  }
+
+  if task.manifest.Until == nil {
+    task.manifest.Until = make(map[string]*ManifestUntil)
+  }
+
+  stats, ok := task.manifest.Until[r.Name]
+  if !ok {
+    stats = &ManifestUntil{
+      Metric: u.Metric,
+      CI:     u.CI,
+    }
+    task.manifest.Until[r.Name] = stats
+  }
+
+  // The metric is measured from the outputs of each repetition on its own
+  val, err := objective.extract(task.outputsDir(ri))
+  if err != nil {
+    log.Warnf("Could not measure %s of run %s-%s-%d: %s",
+      u.Metric,
+      task.UUID(),
+      r.Name,
+      ri.rep,
+      err,
+    )
+  } else {
+    stats.Values = append(stats.Values, val)
+  }
+
+  stats.Repetitions = ri.rep
+  stats.Mean, stats.Stddev, stats.HalfWidth = confidenceInterval(stats.Values, u.CI)
+
+  // The relative width is -1 for as long as it cannot be computed
+  switch {
+  case len(stats.Values) < 2:
+    stats.RelWidth = -1
+  case stats.HalfWidth == 0:
+    stats.RelWidth = 0
+  case stats.Mean != 0:
+    stats.RelWidth = stats.HalfWidth / math.Abs(stats.Mean)
+  default:
+    stats.RelWidth = -1
+  }
+
+  if ri.rep < u.Min {
+    return 0
+  }
+
+  if stats.RelWidth >= 0 && stats.RelWidth <= u.RelWidth {
+    stats.Decision = UntilConverged
+    log.Infof("Run %s-%s converged after %d repetitions with %s=%s ± %s",
+      task.UUID(),
+      r.Name,
+      ri.rep,
+      u.Metric,
+      objective.format(stats.Mean),
+      objective.format(stats.HalfWidth),
+    )
+    return task.stopRepeating(ri.index)
+  } else if ri.rep >= u.Max {
+    stats.Decision = UntilMax
+    log.Warnf("Run %s-%s did not converge within %d repetitions",
+      task.UUID(),
+      r.Name,
+      ri.rep,
+    )
+  }
+
+  return 0
+}
+
+// repeatedMean returns the mean of the metric over the repetitions of the run
+// which was repeated until it
+func (t *Task) repeatedMean(metric string) (float64, error) {
+  if t.manifest == nil {
+    return 0, errNotRepeated
+  }
+
+  for name, stats := range t.manifest.Until {
+    if stats.Metric != metric {
+      continue
+    } else if len(stats.Values) == 0 {
+      return 0, fmt.Errorf("No repetition of run %s was measured", name)
+    }
+
+    return stats.Mean, nil
+  }
+
+  return 0, errNotRepeated
+}
+
+// fixedRepetitionsMean returns the mean of the metric over the succeeded
+// repetitions of the first run which is repeated a fixed number of times and
+// whose outputs contain the metric, measured from each repetition on its own
+func (t *Task) fixedRepetitionsMean(objective *JobObjective) (float64, error) {
+  for i, r := range t.runs {
+    if r.Until != nil || repeats(r) < 2 {
+      continue
+    }
+
+    var values []float64
+    for rep, state := range t.states[i] {
+      if state != runSucceeded {
+        continue
+      }
+
+      val, err := objective.extract(t.outputsDir(runInstance{i, rep + 1}))
+      if err != nil {
+        log.Debugf("Could not measure %s of run %s-%s-%d: %s",
+          objective.Metric,
+          t.UUID(),
+          r.Name,
+          rep + 1,
+          err,
+        )
+        continue
+      }
+
+      values = append(values, val)
+    }
+
+    if len(values) > 0 {
+      return mean(values), nil
+    }
+  }
+
+  return 0, errNotRepeated
+}
+
+// mean returns the arithmetic mean of the values
+func mean(values []float64) float64 {
+  if len(values) == 0 {
+    return 0
+  }
+
+  sum := 0.0
+  for _, val := range values {
+    sum += val
+  }
+
+  return sum / float64(len(values))
+}
+
+// confidenceInterval returns the mean and standard deviation of the values and
+// the half-width of the confidence interval of the mean at the level, using
+// Student's t-distribution
+func confidenceInterval(values []float64, level float64) (float64, float64, float64) {
+  n := float64(len(values))
+  if n == 0 {
+    return 0, 0, 0
+  }
+
+  m := mean(values)
+  if n < 2 {
+    return m, 0, 0
+  }
+
+  variance := 0.0
+  for _, val := range values {
+    variance += (val - m) * (val - m)
+  }
+  stddev := math.Sqrt(variance / (n - 1))
+
+  return m, stddev, tQuantile((1 + level) / 2, n - 1) * stddev / math.Sqrt(n)
+}
+
+// tQuantile returns the quantile p > 0.5 of Student's t-distribution with df
+// degrees of freedom by bisection
+func tQuantile(p, df float64) float64 {
+  lo, hi := 0.0, 1.0
+  for tCdf(hi, df) < p {
+    lo, hi = hi, hi * 2
+  }
+
+  for i := 0; i < 100; i++ {
+    mid := (lo + hi) / 2
+    if tCdf(mid, df) < p {
+      lo = mid
+    } else {
+      hi = mid
+    }
+  }
+
+  return (lo + hi) / 2
+}
+
+// tCdf returns the cumulative distribution function at t >= 0 of Student's
+// t-distribution with df degrees of freedom
+func tCdf(t, df float64) float64 {
+  return 1 - 0.5 * betaInc(df / 2, 0.5, df / (df + t * t))
+}
+
+// betaInc returns the regularized incomplete beta function I_x(a, b)
+func betaInc(a, b, x float64) float64 {
+  if x <= 0 {
+    return 0
+  } else if x >= 1 {
+    return 1
+  }
+
+  lab, _ := math.Lgamma(a + b)
+  la, _ := math.Lgamma(a)
+  lb, _ := math.Lgamma(b)
+  front := math.Exp(lab - la - lb + a * math.Log(x) + b * math.Log(1 - x))
+
+  // The continued fraction converges quickly on this side of the mode
+  if x < (a + 1) / (a + b + 2) {
+    return front * betaFraction(a, b, x) / a
+  }
+
+  return 1 - front * betaFraction(b, a, 1 - x) / b
+}
+
+// betaFraction evaluates the continued fraction of the incomplete beta
+// function using the modified Lentz's method
+func betaFraction(a, b, x float64) float64 {
+  const tiny = 1e-300
+
+  clamp := func(v float64) float64 {
+    if math.Abs(v) < tiny {
+      return tiny
+    }
+    return v
+  }
+
+  c := 1.0
+  d := 1 / clamp(1 - (a + b) * x / (a + 1))
+  h := d
+
+  for m := 1.0; m <= 300; m++ {
+    aa := m * (b - m) * x / ((a + 2 * m - 1) * (a + 2 * m))
+    d = 1 / clamp(1 + aa * d)
+    c = clamp(1 + aa / c)
+    h *= d * c
+
+    aa = -(a + m) * (a + b + m) * x / ((a + 2 * m) * (a + 2 * m + 1))
+    d = 1 / clamp(1 + aa * d)
+    c = clamp(1 + aa / c)
+    delta := d * c
+    h *= delta
+
+    if math.Abs(delta - 1) < 1e-15 {
+      break
+    }
+  }
+
+  return h
+}
@@ -0,0 +1,161 @@
+package job
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "os"
+  "math"
+  "path"
+  "testing"
+  "io/ioutil"
+
+  "github.com/lancs-net/wayfinder/run"
+)
+
+// TestTQuantile checks the quantiles of Student's t-distribution against
+// tabulated values
+func TestTQuantile(t *testing.T) {
+  tests := []struct {
+    p      float64
+    df     float64
+    expect float64
+  }{
+    {0.975, 1, 12.706},
+    {0.975, 2, 4.303},
+    {0.975, 30, 2.042},
+    {0.95, 1, 6.314},
+    {0.95, 10, 1.812},
+    {0.995, 5, 4.032},
+    {0.9, 120, 1.289},
+  }
+
+  for _, test := range tests {
+    if got := tQuantile(test.p, test.df); math.Abs(got - test.expect) > 0.001 {
+      t.Errorf("t(%g, %g): got %.4f, expected %.3f", test.p, test.df, got, test.expect)
+    }
+  }
+}
+
+// TestConfidenceInterval checks the mean, standard deviation and half-width of
+// the confidence interval, which is zero with fewer than two values
+func TestConfidenceInterval(t *testing.T) {
+  tests := []struct {
+    values    []float64
+    mean      float64
+    stddev    float64
+    halfWidth float64
+  }{
+    {nil, 0, 0, 0},
+    {[]float64{5}, 5, 0, 0},
+    {[]float64{99, 100, 101}, 100, 1, 4.303 / math.Sqrt(3)},
+    {[]float64{2, 4, 4, 4, 5, 5, 7, 9}, 5, math.Sqrt(32.0 / 7), 2.365 * math.Sqrt(32.0 / 7) / math.Sqrt(8)},
+  }
+
+  for _, test := range tests {
+    mean, stddev, halfWidth := confidenceInterval(test.values, 0.95)
+    if math.Abs(mean - test.mean) > 1e-9 ||
+        math.Abs(stddev - test.stddev) > 1e-9 ||
+        math.Abs(halfWidth - test.halfWidth) > 1e-3 {
+      t.Errorf("%v: got %g ± %g (stddev %g), expected %g ± %g (stddev %g)",
+        test.values,
+        mean, halfWidth, stddev,
+        test.mean, test.halfWidth, test.stddev,
+      )
+    }
+  }
+}
+
+// TestRepeatUntil checks that the remaining repetitions of a run are dropped
+// once at least the minimum number have finished and the confidence interval
+// of the metric is narrow enough
+func TestRepeatUntil(t *testing.T) {
+  tests := []struct {
+    values  []string
+    dropped []int // after each repetition
+    decision string
+  }{
+    {[]string{"100", "150", "101", "99"}, []int{0, 0, 0, 0}, ""},
+    {[]string{"100", "101", "99"}, []int{0, 0, 7}, UntilConverged},
+    {[]string{"100", "100", "100"}, []int{0, 0, 7}, UntilConverged},
+  }
+
+  for _, test := range tests {
+    resultsDir, err := ioutil.TempDir("", "wayfinder-until")
+    if err != nil {
+      t.Fatal(err)
+    }
+    defer os.RemoveAll(resultsDir)
+
+    j := &Job{
+      Objectives: []JobObjective{{Metric: "rps", Path: "rps.txt"}},
+    }
+
+    task := &Task{
+      runs: []run.Run{{
+        Name:  "bench",
+        Until: &run.RunUntil{Metric: "rps", CI: 0.95, RelWidth: 0.05, Min: 3, Max: 10},
+      }},
+      states:     [][]int{make([]int, 10)},
+      manifest:   &TaskManifest{},
+      uuid:       "task",
+      resultsDir: resultsDir,
+    }
+
+    for i, val := range test.values {
+      ri := runInstance{0, i + 1}
+      dir := task.outputsDir(ri)
+      if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+        t.Fatal(err)
+      }
+      if err := ioutil.WriteFile(path.Join(dir, "rps.txt"), []byte(val), 0644); err != nil {
+        t.Fatal(err)
+      }
+
+      task.completed(ri, true)
+      if dropped := j.repeatUntil(task, ri); dropped != test.dropped[i] {
+        t.Errorf("%v: got %d repetitions dropped after %d, expected %d", test.values, dropped, i + 1, test.dropped[i])
+      }
+    }
+
+    stats := task.manifest.Until["bench"]
+    if stats.Decision != test.decision || len(stats.Values) != len(test.values) {
+      t.Errorf("%v: got decision %q after %d values", test.values, stats.Decision, len(stats.Values))
+    }
+
+    if remaining := len(task.states[0]); test.decision == UntilConverged && remaining != len(test.values) {
+      t.Errorf("%v: got %d repetitions left, expected %d", test.values, remaining, len(test.values))
+    }
+
+    if mean, err := task.repeatedMean("rps"); err != nil || mean != stats.Mean {
+      t.Errorf("%v: got mean %g (%v), expected %g", test.values, mean, err, stats.Mean)
+    }
+  }
+}
@@ -0,0 +1,230 @@
+package job
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "os"
+  "fmt"
+  "strings"
+
+  "github.com/lancs-net/wayfinder/log"
+)
+
+// loadJob reads the job yaml file and the files it is composed of, rejecting
+// unknown attributes.  The job is nil when the file could not be decoded at all.
+func loadJob(filePath string) (*Job, []error) {
+  // Check if the path is set
+  if len(filePath) == 0 {
+    return nil, []error{fmt.Errorf("File path cannot be empty")}
+  }
+
+  // Check if the file exists
+  if _, err := os.Stat(filePath); os.IsNotExist(err) {
+    return nil, []error{fmt.Errorf("File does not exist: %s", filePath)}
+  }
+
+  job, errs := readJobFile(filePath, nil)
+  if job == nil {
+    return nil, errs
+  }
+
+  // Import additional parameters, e.g. from a Kconfig tree
+  if len(job.ParamsFrom.Type) > 0 {
+    imported, err := ImportParams(&job.ParamsFrom)
+    if err != nil {
+      errs = append(errs, fmt.Errorf("Could not import parameters: %s", err))
+    } else {
+      log.Infof("Imported %d parameters from %s", len(imported), job.ParamsFrom.Path)
+      job.Params = mergeParams(imported, job.Params)
+    }
+  }
+
+  return job, errs
+}
+
+// ValidateJob reads the job yaml file and returns all the problems with it,
+// without affecting the host.
+func ValidateJob(filePath string, cfg *RuntimeConfig) []error {
+  job, errs := loadJob(filePath)
+  if job == nil {
+    return errs
+  }
+
+  errs = append(errs, job.validate(cfg)...)
+  if len(errs) > 0 {
+    return errs
+  }
+
+  count, pruned, err := job.countTasks()
+  if err != nil {
+    return []error{err}
+  }
+
+  log.Infof("There are %d tasks after constraints, %d were pruned", count, pruned)
+
+  return nil
+}
+
+// joinErrors combines several problems into a single error
+func joinErrors(errs []error) error {
+  if len(errs) == 1 {
+    return errs[0]
+  }
+
+  var msgs []string
+  for _, err := range errs {
+    msgs = append(msgs, err.Error())
+  }
+
+  return fmt.Errorf("%d problems:\n  %s", len(errs), strings.Join(msgs, "\n  "))
+}
+
+// validate checks the job is well-formed and can run on the available cores,
+// returning all the problems found.  Once there are none, the job's explorer is
+// ready to propose tasks.
+func (j *Job) validate(cfg *RuntimeConfig) []error {
+  var errs []error
+
+  if len(j.Params) == 0 {
+    errs = append(errs, fmt.Errorf("You have not set any parameters"))
+  }
+
+  // Check each parameter has a known type and at least one valid value
+  names := make(map[string]bool)
+  for i, param := range j.Params {
+    if len(param.Name) == 0 {
+      errs = append(errs, fmt.Errorf("Parameter %d has no name", i + 1))
+      continue
+    }
+
+    if names[param.Name] {
+      errs = append(errs, fmt.Errorf("Parameter is defined more than once: %s", param.Name))
+    }
+    names[param.Name] = true
+
+    params, err := paramPermutations(&j.Params[i])
+    if err != nil {
+      errs = append(errs, err)
+    } else if len(params) == 0 {
+      errs = append(errs, fmt.Errorf("Parameter has no values: %s", param.Name))
+    }
+  }
+
+  if err := j.checkGroups(); err != nil {
+    errs = append(errs, err)
+  } else if len(errs) == 0 {
+    if _, err := j.dimensions(); err != nil {
+      errs = append(errs, err)
+    }
+  }
+
+  // Parse the conditions under which parameters are active
+  if err := j.compileConditions(); err != nil {
+    errs = append(errs, err)
+  }
+
+  // Parse the constraints which tasks must satisfy
+  if err := j.compileConstraints(); err != nil {
+    errs = append(errs, err)
+  }
+
+  // Check if each run is stasifyable
+  if len(j.Runs) == 0 {
+    errs = append(errs, fmt.Errorf("You have not set any runs"))
+  }
+
+  for i, run := range j.Runs {
+    errs = append(errs, run.Validate()...)
+
+    // Check if this particular run has requested more cores than what is
+    if run.Cores > len(cfg.Cpus) {
+      errs = append(errs, fmt.Errorf(
+        "Run has too many cores: %s: %d > %d",
+        run.Name,
+        run.Cores,
+        len(cfg.Cpus),
+      ))
+
+    // Set the default number of cores to use
+    } else if run.Cores == 0 {
+      j.Runs[i].Cores = 1
+    }
+  }
+
+  errs = append(errs, j.checkDependencies()...)
+
+  for _, input := range j.Inputs {
+    if err := input.Validate(); err != nil {
+      errs = append(errs, err)
+    }
+  }
+
+  // Check the templates evaluated for each task can be parsed
+  errs = append(errs, j.checkTemplates()...)
+
+  for _, output := range j.Outputs {
+    if len(output.Path) == 0 {
+      errs = append(errs, fmt.Errorf("Output has no path: %s", output.Name))
+    }
+  }
+
+  if err := j.Order.validate(); err != nil {
+    errs = append(errs, err)
+  } else {
+    j.Order.resolve()
+  }
+
+  // Check the objectives of the job can be measured
+  if err := j.resolveObjectives(); err != nil {
+    errs = append(errs, err)
+  } else {
+    for _, r := range j.Runs {
+      if r.Until != nil && len(r.Until.Metric) > 0 && j.objective(r.Until.Metric) == nil {
+        errs = append(errs, fmt.Errorf("Run %s is repeated until unknown objective: %s", r.Name, r.Until.Metric))
+      }
+    }
+  }
+
+  // Prepare the technique used to explore the parameter space, which relies
+  // on everything else being valid
+  explorer, err := NewExplorer(&j.Explorer)
+  if err != nil {
+    errs = append(errs, err)
+  } else if len(errs) == 0 {
+    err = explorer.Init(j)
+    if err != nil {
+      errs = append(errs, fmt.Errorf("Could not initialize explorer: %s", err))
+    }
+    j.explorer = explorer
+  }
+
+  return errs
+}
@@ -86,8 +86,8 @@ type Image struct {
   Tag        string
 }
 
-// PullImage downloads an image
-func PullImage(image, cacheDir string) (v1.Image, error) {
+// imageOptions returns the options used to fetch images
+func imageOptions() []crane.Option {
   var options []crane.Option
 
   // options = append(options, crane.Insecure)
@@ -98,21 +98,39 @@ func PullImage(image, cacheDir string) (v1.Image, error) {
     Architecture: runtime.GOARCH,
   }))
 
+  return options
+}
+
+// ImageDigest returns the digest of the image's configuration from its remote
+// manifest, without pulling the image
+func ImageDigest(image string) (string, error) {
   // Grab the remote manifest
-  manifest, err := crane.Manifest(image, options...)
+  manifest, err := crane.Manifest(image, imageOptions()...)
   if err != nil {
-    return nil, fmt.Errorf("failed fetching manifest for %s: %v", image, err)
+    return "", fmt.Errorf("failed fetching manifest for %s: %v", image, err)
   }
 
   if !gjson.Valid(string(manifest)) {
-    return nil, fmt.Errorf("Cannot parse manifest: %s", string(manifest))
+    return "", fmt.Errorf("Cannot parse manifest: %s", string(manifest))
   }
 
-  value := gjson.Get(string(manifest), "config.digest").Value().(string)
-  if value == "" {
-    return nil, fmt.Errorf("Malformed manifest: %s", string(manifest))
+  value := gjson.Get(string(manifest), "config.digest").String()
+  if value == "" || !strings.Contains(value, ":") {
+    return "", fmt.Errorf("Malformed manifest: %s", string(manifest))
   }
-  
+
+  return value, nil
+}
+
+// PullImage downloads an image
+func PullImage(image, cacheDir string) (v1.Image, error) {
+  options := imageOptions()
+
+  value, err := ImageDigest(image)
+  if err != nil {
+    return nil, err
+  }
+
   digest := strings.Split(value, ":")[1]
   tarball := fmt.Sprintf("%s/%s.tar.gz", cacheDir, digest)
 
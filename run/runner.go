@@ -36,6 +36,7 @@ import (
   "time"
   "path"
   "strings"
+  "context"
 
   "golang.org/x/sys/unix"
   "github.com/otiai10/copy"
@@ -48,6 +49,13 @@ import (
   "github.com/lancs-net/wayfinder/log"
 )
 
+// ErrTimeout is returned when the run did not finish within its timeout
+var ErrTimeout = fmt.Errorf("Run timed out")
+
+// DefaultKillGrace is how long a run which timed out is given to stop before
+// it is killed
+const DefaultKillGrace = 10 * time.Second
+
 var (
   defaultEnvironment = []string{
     "TERM=xterm",
@@ -70,6 +78,12 @@ var (
     "CAP_KILL",
     "CAP_AUDIT_WRITE",
   }
+  knownDevices = []string{
+    "/dev/kvm",
+    "/dev/net/tun",
+    "/dev/random",
+    "/dev/urandom",
+  }
 )
 
 type Run struct {
@@ -80,10 +94,161 @@ type Run struct {
   Cmd            string `yaml:"cmd"`
   Path           string `yaml:"path"`
   Capabilities []string
+  DependsOn    []string `yaml:"depends_on"`
+  Repeat         int    `yaml:"repeat"`
+  Memory         string `yaml:"memory"`
+  MemorySwap     string `yaml:"memory_swap"`
+  Hugepages      map[string]string `yaml:"hugepages"`
+  PidsLimit      int64  `yaml:"pids_limit"`
+  Duration       string `yaml:"duration"` // expected duration, used for planning
+  Timeout        string `yaml:"timeout"`
+  Until         *RunUntil `yaml:"until"`
   exitCode       int
   maxRetries     int
 }
 
+// RunUntil repeats a run until the confidence interval of the mean of a metric
+// is narrow enough, or the maximum number of repetitions is reached
+type RunUntil struct {
+  Metric   string  `yaml:"metric"    json:"metric"`
+  CI       float64 `yaml:"ci"        json:"ci"`        // confidence level
+  RelWidth float64 `yaml:"rel_width" json:"rel_width"` // half-width relative to the mean
+  Min      int     `yaml:"min"       json:"min"`
+  Max      int     `yaml:"max"       json:"max"`
+}
+
+// Validate returns all the problems with the run's configuration
+func (r *Run) Validate() []error {
+  var errs []error
+
+  if len(r.Name) == 0 {
+    errs = append(errs, fmt.Errorf("Run has no name"))
+  }
+
+  if len(r.Image) == 0 {
+    errs = append(errs, fmt.Errorf("Run has no image: %s", r.Name))
+  }
+
+  if len(r.Cmd) == 0 && len(r.Path) == 0 {
+    errs = append(errs, fmt.Errorf("Run has neither cmd nor path: %s", r.Name))
+  }
+
+  if r.Cores < 0 {
+    errs = append(errs, fmt.Errorf("Run has negative cores: %s: %d", r.Name, r.Cores))
+  }
+
+  var memory int64
+  if len(r.Memory) > 0 {
+    var err error
+    memory, err = ParseSize(r.Memory)
+    if err != nil {
+      errs = append(errs, fmt.Errorf("Invalid memory for run %s: %s", r.Name, err))
+    }
+  }
+
+  if len(r.MemorySwap) > 0 {
+    swap, err := ParseSwap(r.MemorySwap)
+    if err != nil {
+      errs = append(errs, fmt.Errorf("Invalid memory_swap for run %s: %s", r.Name, err))
+    } else if len(r.Memory) == 0 {
+      errs = append(errs, fmt.Errorf("Run has memory_swap without memory: %s", r.Name))
+    } else if swap >= 0 && swap < memory {
+      errs = append(errs, fmt.Errorf("Run has less memory_swap than memory: %s", r.Name))
+    }
+  }
+
+  for pagesize, limit := range r.Hugepages {
+    if !pagesizeRegexp.MatchString(pagesize) {
+      errs = append(errs, fmt.Errorf("Invalid hugepage size for run %s: %s", r.Name, pagesize))
+    }
+    if _, err := ParseSize(limit); err != nil {
+      errs = append(errs, fmt.Errorf("Invalid hugepages limit for run %s: %s", r.Name, err))
+    }
+  }
+
+  if r.Repeat < 0 {
+    errs = append(errs, fmt.Errorf("Run has negative repeat: %s: %d", r.Name, r.Repeat))
+  }
+
+  if r.Until != nil {
+    errs = append(errs, r.validateUntil()...)
+  }
+
+  if r.PidsLimit < 0 {
+    errs = append(errs, fmt.Errorf("Run has negative pids_limit: %s: %d", r.Name, r.PidsLimit))
+  }
+
+  if len(r.Duration) > 0 {
+    if _, err := time.ParseDuration(r.Duration); err != nil {
+      errs = append(errs, fmt.Errorf("Invalid duration for run %s: %s", r.Name, err))
+    }
+  }
+
+  if len(r.Timeout) > 0 {
+    if timeout, err := time.ParseDuration(r.Timeout); err != nil {
+      errs = append(errs, fmt.Errorf("Invalid timeout for run %s: %s", r.Name, err))
+    } else if timeout <= 0 {
+      errs = append(errs, fmt.Errorf("Run has non-positive timeout: %s: %s", r.Name, r.Timeout))
+    }
+  }
+
+  for _, device := range r.Devices {
+    known := false
+    for _, d := range knownDevices {
+      if device == d {
+        known = true
+      }
+    }
+    if !known {
+      errs = append(errs, fmt.Errorf("Unknown device for run %s: %s", r.Name, device))
+    }
+  }
+
+  return errs
+}
+
+// validateUntil returns the problems with when the run stops being repeated
+// and sets the defaults of those attributes which are not
+func (r *Run) validateUntil() []error {
+  var errs []error
+  u := r.Until
+
+  if len(u.Metric) == 0 {
+    errs = append(errs, fmt.Errorf("Run has until without metric: %s", r.Name))
+  }
+
+  if r.Repeat > 0 {
+    errs = append(errs, fmt.Errorf("Run has both repeat and until: %s", r.Name))
+  }
+
+  if u.CI == 0 {
+    u.CI = 0.95
+  } else if u.CI <= 0 || u.CI >= 1 {
+    errs = append(errs, fmt.Errorf("Invalid ci for run %s: %g", r.Name, u.CI))
+  }
+
+  if u.RelWidth <= 0 {
+    errs = append(errs, fmt.Errorf("Run has until without positive rel_width: %s", r.Name))
+  }
+
+  if u.Min == 0 {
+    u.Min = 3
+  } else if u.Min < 2 {
+    errs = append(errs, fmt.Errorf("Run must be repeated at least twice until: %s: %d", r.Name, u.Min))
+  }
+
+  if u.Max == 0 {
+    u.Max = 20
+    if u.Min > u.Max {
+      u.Max = u.Min
+    }
+  } else if u.Max < u.Min {
+    errs = append(errs, fmt.Errorf("Run has until with max below min: %s: %d < %d", r.Name, u.Max, u.Min))
+  }
+
+  return errs
+}
+
 type Runner struct {
   log        *log.Logger
   Config     *RunnerConfig
@@ -98,9 +263,30 @@ type Input struct {
   Name             string `yaml:"name"`
   Source           string `yaml:"source"`
   Destination      string `yaml:"destination"`
+  Content          string `yaml:"content"`
+  Template         bool   `yaml:"template"`
   Options        []string `yaml:"options"`
 }
 
+// Validate returns whether the input can be copied into a run
+func (i *Input) Validate() error {
+  if len(i.Destination) == 0 {
+    return fmt.Errorf("Input has no destination: %s", i.Source)
+  }
+
+  // Inline content needs no source, and templated sources can only be checked
+  // once they are rendered for a task
+  if len(i.Content) > 0 || strings.Contains(i.Source, "{{") {
+    return nil
+  }
+
+  if _, err := os.Stat(i.Source); err != nil {
+    return fmt.Errorf("Input source does not exist: %s", i.Source)
+  }
+
+  return nil
+}
+
 type Output struct {
   Name             string `yaml:"name"`
   Path             string `yaml:"path"`
@@ -109,10 +295,19 @@ type Output struct {
 type RunnerConfig struct {
   Log             *log.Logger
   ResultsDir       string
+  OutputsDir       string   // where outputs are saved after the run
+  OutputsFrom    []string   // where outputs of previous runs are taken from
   CacheDir         string
   Name             string
   Image            string
   CoreIds        []int
+  MemNodes       []int
+  Memory           int64 // limit in bytes, 0 for no limit
+  MemorySwap       int64 // limit of memory and swap in bytes, -1 for no limit
+  Hugepages        map[string]uint64 // limit in bytes by page size
+  PidsLimit        int64
+  Timeout          time.Duration // 0 for no timeout
+  KillGrace        time.Duration // between stopping and killing a timed out run
   Devices        []string
   Path             string
   Cmd              string
@@ -132,6 +327,13 @@ func NewRunner(cfg *RunnerConfig, bridge *Bridge, dryRun bool) (*Runner, error)
 
   cfg.Image = ref.Remote()
 
+  if cfg.OutputsDir == "" {
+    cfg.OutputsDir = cfg.ResultsDir
+  }
+  if cfg.OutputsFrom == nil {
+    cfg.OutputsFrom = []string{cfg.ResultsDir}
+  }
+
   runner := &Runner{
     Config: cfg,
     Bridge: bridge,
@@ -185,14 +387,18 @@ func (r *Runner) Init(in *[]Input, out *[]Output, dryRun bool) error {
   }
 
   // Copy outputs between runs
-  for _, output := range *out {
-    r.log.Debugf("Copying output into rootfs: %s", output.Path)
-    err := copy.Copy(
-      path.Join(r.Config.ResultsDir, output.Path),
-      path.Join(r.rootfs, output.Path),
-    )
-    if err != nil {
-      r.log.Warnf("Could not copy result: %s", err)
+  for _, dir := range r.Config.OutputsFrom {
+    for _, output := range *out {
+      src := path.Join(dir, output.Path)
+      if _, err := os.Stat(src); os.IsNotExist(err) {
+        continue
+      }
+
+      r.log.Debugf("Copying output into rootfs: %s", src)
+      err := copy.Copy(src, path.Join(r.rootfs, output.Path))
+      if err != nil {
+        r.log.Warnf("Could not copy result: %s", err)
+      }
     }
   }
 
@@ -282,6 +488,14 @@ func (r *Runner) Init(in *[]Input, out *[]Output, dryRun bool) error {
     capabilities = append(capabilities, capability)
   }
 
+  var hugetlbLimits []*configs.HugepageLimit
+  for pagesize, limit := range r.Config.Hugepages {
+    hugetlbLimits = append(hugetlbLimits, &configs.HugepageLimit{
+      Pagesize: pagesize,
+      Limit:    limit,
+    })
+  }
+
   config := &configs.Config{
     Rootfs: r.rootfs,
     Capabilities: &configs.Capabilities{
@@ -308,6 +522,14 @@ func (r *Runner) Init(in *[]Input, out *[]Output, dryRun bool) error {
         CpusetCpus:       strings.Trim(
           strings.Join(strings.Fields(fmt.Sprint(r.Config.CoreIds)), ","), "[]",
         ),
+        // Keep the memory of the run on the NUMA nodes of its cores
+        CpusetMems:       strings.Trim(
+          strings.Join(strings.Fields(fmt.Sprint(r.Config.MemNodes)), ","), "[]",
+        ),
+        Memory:           r.Config.Memory,
+        MemorySwap:       r.Config.MemorySwap,
+        HugetlbLimit:     hugetlbLimits,
+        PidsLimit:        r.Config.PidsLimit,
         // Set the share to 100 so that the container has the whole CPU share
         CpuShares:        100,
       },
@@ -464,8 +686,8 @@ func (r *Runner) Init(in *[]Input, out *[]Output, dryRun bool) error {
   return nil
 }
 
-// Run the runc container
-func (r *Runner) Run() (int, time.Duration, error) {
+// Run the runc container until it exits, times out or the context is cancelled
+func (r *Runner) Run(ctx context.Context) (int, time.Duration, error) {
   if r.container == nil {
     return 1, -1, fmt.Errorf("Cannot run container, missing initialization")
   }
@@ -490,13 +712,78 @@ func (r *Runner) Run() (int, time.Duration, error) {
     return 1, -1, fmt.Errorf("Could not run task process: %s", err)
   }
 
-  // Wait for the process to finish
-  state, err := taskProcess.Wait()
+  // Wait for the process to finish in the background, such that it can be
+  // stopped once the run times out
+  done := make(chan waitResult, 1)
+  go func() {
+    state, err := taskProcess.Wait()
+    done <- waitResult{state, err}
+  }()
+
+  var timeout <-chan time.Time
+  if r.Config.Timeout > 0 {
+    timer := time.NewTimer(r.Config.Timeout)
+    defer timer.Stop()
+    timeout = timer.C
+  }
+
+  select {
+  case res := <-done:
+    if res.err != nil {
+      return 1, -1, fmt.Errorf("Could not wait for container to finish: %s", res.err)
+    }
+
+    return res.state.ExitCode(), time.Since(r.timer), nil
+
+  case <-timeout:
+    elapsed := time.Since(r.timer)
+    r.log.Warnf("Run timed out after %s", r.Config.Timeout)
+    r.stop(done)
+    return 1, elapsed, ErrTimeout
+
+  case <-ctx.Done():
+    r.log.Warnf("Stopping run")
+    r.stop(done)
+    return 1, -1, ctx.Err()
+  }
+}
+
+// waitResult is how the process of the run exited
+type waitResult struct {
+  state *os.ProcessState
+  err    error
+}
+
+// stop signals the init process of the container to terminate and kills all
+// of the container's processes if it has not done so within the grace period
+func (r *Runner) stop(done chan waitResult) {
+  grace := r.Config.KillGrace
+  if grace <= 0 {
+    grace = DefaultKillGrace
+  }
+
+  err := r.container.Signal(unix.SIGTERM, false)
   if err != nil {
-    return 1, -1, fmt.Errorf("Could not wait for container to finish: %s", err)
+    r.log.Warnf("Could not signal container: %s", err)
   }
 
-  return state.ExitCode(), time.Since(r.timer), nil
+  select {
+  case <-done:
+    return
+  case <-time.After(grace):
+  }
+
+  r.log.Warnf("Run did not stop within %s, killing it", grace)
+  err = r.container.Signal(unix.SIGKILL, true)
+  if err != nil {
+    r.log.Warnf("Could not kill container: %s", err)
+  }
+
+  select {
+  case <-done:
+  case <-time.After(grace):
+    r.log.Warnf("Run did not stop after being killed")
+  }
 }
 
 // Destroy the runc container
@@ -509,7 +796,7 @@ func (r *Runner) Destroy() error {
       r.log.Debugf("Copying result: %s", output.Path)
       err := copy.Copy(
         path.Join(r.rootfs, output.Path),
-        path.Join(r.Config.ResultsDir, output.Path),
+        path.Join(r.Config.OutputsDir, output.Path),
       )
       if err != nil {
         r.log.Warnf("Could not copy result: %s", err)
@@ -0,0 +1,80 @@
+package run
+// SPDX-License-Identifier: BSD-3-Clause
+//
+// Authors: Alexander Jung <a.jung@lancs.ac.uk>
+//
+// Copyright (c) 2020, Lancaster University.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+import (
+  "fmt"
+  "regexp"
+  "strconv"
+  "strings"
+)
+
+var (
+  sizeRegexp     = regexp.MustCompile(`^([0-9]+)\s*(?:([kKmMgGtT])i?)?[bB]?$`)
+  pagesizeRegexp = regexp.MustCompile(`^[0-9]+(KB|MB|GB)$`)
+)
+
+// ParseSize parses a size in bytes which is optionally followed by a unit,
+// e.g. 512M, 2GiB or 1g.  Units are multiples of 1024.
+func ParseSize(size string) (int64, error) {
+  matches := sizeRegexp.FindStringSubmatch(strings.TrimSpace(size))
+  if matches == nil {
+    return 0, fmt.Errorf("Invalid size: %s", size)
+  }
+
+  val, err := strconv.ParseInt(matches[1], 10, 64)
+  if err != nil {
+    return 0, fmt.Errorf("Invalid size: %s", size)
+  }
+
+  for _, unit := range "KMGT" {
+    if strings.ToUpper(matches[2]) == "" {
+      break
+    }
+
+    val *= 1024
+    if strings.ToUpper(matches[2]) == string(unit) {
+      break
+    }
+  }
+
+  return val, nil
+}
+
+// ParseSwap parses the combined limit of memory and swap, where -1 means no
+// limit on swap
+func ParseSwap(size string) (int64, error) {
+  if strings.TrimSpace(size) == "-1" {
+    return -1, nil
+  }
+
+  return ParseSize(size)
+}